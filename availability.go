@@ -0,0 +1,117 @@
+package torrent
+
+import "github.com/anacrolix/missinggo/bitmap"
+
+// How ReaderPiecePicker orders pieces of the same priority.
+type PieceSelectionAlgorithm int
+
+const (
+	// Request the pieces the fewest connected peers have first, so they
+	// spread through the swarm before the peers that have them leave. Ties
+	// are broken randomly.
+	PieceSelectionRarestFirst PieceSelectionAlgorithm = iota
+	// Each connection requests pieces in its own random order.
+	PieceSelectionRandom
+)
+
+// Availability beyond this doesn't affect the order pieces are requested
+// in. It bounds the request order values so that priority classes stay
+// apart.
+const maxAvailabilityRank = 32
+
+// Returns the rank of the piece's availability in the request order.
+func (t *Torrent) pieceAvailabilityRank(piece int) int {
+	a := t.pieces[piece].availability
+	if a > maxAvailabilityRank {
+		a = maxAvailabilityRank
+	}
+	return a
+}
+
+// Adjusts the number of connected peers that have the piece. Pieces whose
+// rank changes are reordered by updateAvailabilityOrder.
+func (t *Torrent) pieceAvailabilityChanged(piece int, delta int) {
+	p := &t.pieces[piece]
+	before := t.pieceAvailabilityRank(piece)
+	p.availability += delta
+	if t.pieceAvailabilityRank(piece) != before {
+		t.availabilityChanged.Add(piece)
+	}
+}
+
+// Reorders the requests of connections other than c, which is the cause of
+// the changes, for the pieces whose availability rank has changed. Each
+// connection updates its requests once for the whole set.
+func (t *Torrent) updateAvailabilityOrder(c *connection) {
+	if t.availabilityChanged.IsEmpty() {
+		return
+	}
+	changed := t.availabilityChanged
+	t.availabilityChanged = bitmap.Bitmap{}
+	for _, oc := range t.conns {
+		if oc == c {
+			continue
+		}
+		dirty := false
+		changed.IterTyped(func(piece int) bool {
+			if oc.PeerHasPiece(piece) {
+				oc.setPieceOrder(piece)
+				dirty = true
+			}
+			return true
+		})
+		if dirty {
+			oc.updateRequests()
+		}
+	}
+}
+
+// Counts or discounts all of the connection's pieces from their
+// availability.
+func (t *Torrent) addConnPieceAvailability(c *connection, delta int) {
+	if !t.haveInfo() {
+		return
+	}
+	c.havePieces().IterTyped(func(piece int) bool {
+		t.pieceAvailabilityChanged(piece, delta)
+		return true
+	})
+	t.updateAvailabilityOrder(c)
+}
+
+// Returns the pieces the peer has. Don't call this before the info is
+// available.
+func (cn *connection) havePieces() (ret bitmap.Bitmap) {
+	if cn.peerHasAll {
+		ret.AddRange(0, cn.t.numPieces())
+		return
+	}
+	return cn.peerPieces.Copy()
+}
+
+// Applies f, which changes the pieces the peer has, and updates piece
+// availability and the request order accordingly.
+func (cn *connection) changePeerPieces(f func()) {
+	t := cn.t
+	if !t.haveInfo() {
+		f()
+		cn.peerPiecesChanged()
+		return
+	}
+	had := cn.havePieces()
+	f()
+	has := cn.havePieces()
+	gained := has.Copy()
+	gained.Sub(&had)
+	had.Sub(&has)
+	gained.IterTyped(func(piece int) bool {
+		t.pieceAvailabilityChanged(piece, 1)
+		return true
+	})
+	had.IterTyped(func(piece int) bool {
+		t.pieceAvailabilityChanged(piece, -1)
+		return true
+	})
+	t.updateAvailabilityOrder(cn)
+	cn.peerPiecesChanged()
+}
@@ -0,0 +1,85 @@
+package torrent
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// Adds a torrent of numPieces single chunk pieces, marks them for download,
+// and waits for their initial check.
+func testTorrentPieces(t *testing.T, cl *Client, numPieces int) *Torrent {
+	ie := metainfo.InfoEx{
+		Info: metainfo.Info{
+			PieceLength: defaultChunkSize,
+			// Doesn't match the empty data in storage.
+			Pieces: bytes.Repeat([]byte{1}, numPieces*20),
+			Length: int64(numPieces) * defaultChunkSize,
+		},
+	}
+	ie.UpdateBytes()
+	tt, _, err := cl.AddTorrentSpec(&TorrentSpec{
+		Info:     &ie,
+		InfoHash: ie.Hash(),
+	})
+	require.NoError(t, err)
+	tt.DownloadAll()
+	cl.mu.Lock()
+	for i := range tt.pieces {
+		for tt.pieces[i].QueuedForHash || tt.pieces[i].Hashing {
+			cl.event.Wait()
+		}
+	}
+	cl.mu.Unlock()
+	return tt
+}
+
+func testAddConn(tt *Torrent) *connection {
+	c := &connection{
+		t:               tt,
+		PeerMaxRequests: 250,
+	}
+	tt.addConn(c)
+	return c
+}
+
+// Returns the pieces in the order the connection would request them.
+func requestOrder(c *connection) (ret []int) {
+	c.pieceRequestOrder.IterTyped(func(piece int) bool {
+		ret = append(ret, piece)
+		return true
+	})
+	return
+}
+
+func TestRarestFirst(t *testing.T) {
+	cl, err := NewClient(&TestingConfig)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt := testTorrentPieces(t, cl, 3)
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	c1, c2, c3 := testAddConn(tt), testAddConn(tt), testAddConn(tt)
+	require.NoError(t, c3.peerSentHaveAll())
+	require.NoError(t, c1.peerSentBitfield([]bool{true, true, false, false, false, false, false, false}))
+	require.NoError(t, c2.peerSentHave(1))
+	availability := func() (ret []int) {
+		for i := range tt.pieces {
+			ret = append(ret, tt.pieces[i].availability)
+		}
+		return
+	}
+	assert.Equal(t, []int{2, 3, 1}, availability())
+	assert.Equal(t, []int{2, 0, 1}, requestOrder(c3))
+	require.NoError(t, c1.peerSentHaveNone())
+	assert.Equal(t, []int{1, 2, 1}, availability())
+	assert.Equal(t, 1, requestOrder(c3)[2])
+	// The change set has been applied to the other conns.
+	assert.True(t, tt.availabilityChanged.IsEmpty())
+	tt.deleteConnection(c3)
+	assert.Equal(t, []int{0, 1, 0}, availability())
+}
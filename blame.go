@@ -0,0 +1,230 @@
+package torrent
+
+import (
+	"crypto/sha1"
+	"hash"
+	"log"
+	"net"
+
+	"github.com/anacrolix/missinggo"
+	"github.com/anacrolix/missinggo/bitmap"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// When a piece made from the chunks of several peers fails its hash, we
+// can't tell which of them sent bad data. We remember a hash of each chunk
+// and who sent it, and download the piece again from a single peer we trust.
+// If that fails too, it's the fault of that peer alone. If it passes, the
+// chunks that differ from what we had identify the culprits. Peers are only
+// struck on such proof, and banned after Config.BanThreshold strikes.
+
+const defaultBanThreshold = 2
+
+// A chunk of a piece that failed its hash.
+type blamedChunk struct {
+	ip  string
+	sum metainfo.Hash
+}
+
+func connIP(c *connection) string {
+	return missinggo.AddrIP(c.remoteAddr()).String()
+}
+
+func (cl *Client) banThreshold() int {
+	if cl.config.BanThreshold > 0 {
+		return cl.config.BanThreshold
+	}
+	return defaultBanThreshold
+}
+
+// Returns the IPs that have been banned for sending bad data.
+func (cl *Client) BannedIPs() (ret []net.IP) {
+	cl.mu.RLock()
+	defer cl.mu.RUnlock()
+	for ip := range cl.badPeerIPs {
+		ret = append(ret, net.ParseIP(ip))
+	}
+	return
+}
+
+// Returns the set of IPs that supplied the piece's dirty chunks.
+func (p *piece) suppliers() map[string]struct{} {
+	ret := make(map[string]struct{})
+	for _, ip := range p.chunkSuppliers {
+		ret[ip] = struct{}{}
+	}
+	return ret
+}
+
+// Returns true if hashing the piece should also hash each chunk, for blame.
+func (p *piece) wantChunkSums() bool {
+	return p.failedChunks != nil || len(p.suppliers()) > 1
+}
+
+// Returns false if the piece is being redownloaded from a single peer, and
+// it's not c.
+func (p *piece) mayRequestFrom(c *connection) bool {
+	return p.redownloadFrom == nil || p.redownloadFrom == c
+}
+
+func (t *Torrent) pieceHashFailed(piece int, chunkSums []metainfo.Hash) {
+	p := &t.pieces[piece]
+	suppliers := p.suppliers()
+	chunkSuppliers := p.chunkSuppliers
+	p.chunkSuppliers = nil
+	if len(suppliers) == 1 {
+		// One peer supplied the entire bad piece.
+		for ip := range suppliers {
+			t.strikePeerIP(ip)
+		}
+		t.setRedownloadFrom(piece, nil)
+	} else if len(suppliers) > 1 {
+		if p.failedChunks == nil && chunkSums != nil {
+			p.failedChunks = make(map[int]blamedChunk)
+			for i, ip := range chunkSuppliers {
+				if i < len(chunkSums) {
+					p.failedChunks[i] = blamedChunk{ip, chunkSums[i]}
+				}
+			}
+		}
+		t.setRedownloadFrom(piece, t.trustedConn(piece, suppliers))
+	}
+	for _, c := range t.conns {
+		c.updatePiecePriority(piece)
+	}
+}
+
+func (t *Torrent) pieceHashPassed(piece int, chunkSums []metainfo.Hash) {
+	p := &t.pieces[piece]
+	if p.failedChunks != nil && chunkSums != nil {
+		culprits := make(map[string]struct{})
+		for i, bc := range p.failedChunks {
+			if i < len(chunkSums) && bc.sum != chunkSums[i] {
+				culprits[bc.ip] = struct{}{}
+			}
+		}
+		for ip := range culprits {
+			t.strikePeerIP(ip)
+		}
+	}
+	p.failedChunks = nil
+	p.chunkSuppliers = nil
+	t.setRedownloadFrom(piece, nil)
+}
+
+// Restricts the piece to being downloaded from c, or lifts the restriction
+// if c is nil.
+func (t *Torrent) setRedownloadFrom(piece int, c *connection) {
+	p := &t.pieces[piece]
+	if p.redownloadFrom != nil {
+		p.redownloadFrom.redownloads.Remove(piece)
+	}
+	p.redownloadFrom = c
+	if c != nil {
+		c.redownloads.Add(piece)
+	}
+}
+
+// Lets any peer supply the pieces that were to be redownloaded from c.
+func (t *Torrent) releaseRedownloads(c *connection) {
+	if c.redownloads.IsEmpty() {
+		return
+	}
+	pieces := c.redownloads
+	c.redownloads = bitmap.Bitmap{}
+	pieces.IterTyped(func(piece int) bool {
+		t.pieces[piece].redownloadFrom = nil
+		for _, c := range t.conns {
+			c.updatePiecePriority(piece)
+		}
+		return true
+	})
+}
+
+// Returns the connection with the best record that has the piece, preferring
+// those not under suspicion.
+func (t *Torrent) trustedConn(piece int, suspects map[string]struct{}) (ret *connection) {
+	better := func(c *connection) bool {
+		if ret == nil {
+			return true
+		}
+		_, cSuspect := suspects[connIP(c)]
+		_, retSuspect := suspects[connIP(ret)]
+		if cSuspect != retSuspect {
+			return retSuspect
+		}
+		return c.goodPiecesDirtied-c.badPiecesDirtied > ret.goodPiecesDirtied-ret.badPiecesDirtied
+	}
+	for _, c := range t.conns {
+		if c.PeerHasPiece(piece) && better(c) {
+			ret = c
+		}
+	}
+	return
+}
+
+// Records proof that the IP sent bad data, and bans it if it's reached the
+// threshold.
+func (t *Torrent) strikePeerIP(ip string) {
+	cl := t.cl
+	if cl.peerStrikes == nil {
+		cl.peerStrikes = make(map[string]int)
+	}
+	cl.peerStrikes[ip]++
+	if cl.peerStrikes[ip] < cl.banThreshold() {
+		return
+	}
+	log.Printf("%s: banning %s after %d bad pieces", t, ip, cl.peerStrikes[ip])
+	cl.banPeerIP(net.ParseIP(ip))
+	for _, c := range append([]*connection(nil), t.conns...) {
+		if connIP(c) == ip {
+			t.dropConnection(c)
+		}
+	}
+}
+
+// Hashes each chunkSize run of data written to it.
+type chunkHasher struct {
+	chunkSize int64
+	sums      []metainfo.Hash
+	h         hash.Hash
+	n         int64
+}
+
+func (me *chunkHasher) Write(b []byte) (n int, err error) {
+	for len(b) != 0 {
+		if me.h == nil {
+			me.h = sha1.New()
+			me.n = 0
+		}
+		b1 := b
+		if int64(len(b1)) > me.chunkSize-me.n {
+			b1 = b1[:me.chunkSize-me.n]
+		}
+		me.h.Write(b1)
+		me.n += int64(len(b1))
+		n += len(b1)
+		b = b[len(b1):]
+		if me.n == me.chunkSize {
+			me.flush()
+		}
+	}
+	return
+}
+
+func (me *chunkHasher) flush() {
+	if me.h == nil {
+		return
+	}
+	var sum metainfo.Hash
+	missinggo.CopyExact(&sum, me.h.Sum(nil))
+	me.sums = append(me.sums, sum)
+	me.h = nil
+}
+
+// Returns the sums of all the chunks written, including a final short one.
+func (me *chunkHasher) Sums() []metainfo.Hash {
+	me.flush()
+	return me.sums
+}
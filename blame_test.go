@@ -0,0 +1,89 @@
+package torrent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+func TestChunkHasher(t *testing.T) {
+	ch := chunkHasher{chunkSize: 3}
+	ch.Write([]byte("hel"))
+	ch.Write([]byte("lo, w"))
+	ch.Write([]byte("orld!"))
+	sums := ch.Sums()
+	require.Len(t, sums, 5)
+	assert.Equal(t, metainfo.HashBytes([]byte("hel")), sums[0])
+	assert.Equal(t, metainfo.HashBytes([]byte("lo,")), sums[1])
+	assert.Equal(t, metainfo.HashBytes([]byte("!")), sums[4])
+}
+
+func TestBlameFindsCulpritAfterRedownload(t *testing.T) {
+	cfg := TestingConfig
+	cfg.BanThreshold = 1
+	cl, err := NewClient(&cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	ie := metainfo.InfoEx{
+		Info: metainfo.Info{
+			PieceLength: 2 * defaultChunkSize,
+			Pieces:      make([]byte, 20),
+			Length:      2 * defaultChunkSize,
+		},
+	}
+	ie.UpdateBytes()
+	tt, _, err := cl.AddTorrentSpec(&TorrentSpec{
+		Info:     &ie,
+		InfoHash: ie.Hash(),
+	})
+	require.NoError(t, err)
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	p := &tt.pieces[0]
+	p.chunkSuppliers = map[int]string{0: "1.2.3.4", 1: "5.6.7.8"}
+	assert.True(t, p.wantChunkSums())
+	good := []metainfo.Hash{metainfo.HashBytes([]byte("a")), metainfo.HashBytes([]byte("b"))}
+	bad := []metainfo.Hash{good[0], metainfo.HashBytes([]byte("c"))}
+	tt.pieceHashFailed(0, bad)
+	// Neither peer can be blamed yet.
+	assert.Empty(t, cl.badPeerIPs)
+	assert.Len(t, p.failedChunks, 2)
+	tt.pieceHashPassed(0, good)
+	assert.Contains(t, cl.badPeerIPs, "5.6.7.8")
+	assert.NotContains(t, cl.badPeerIPs, "1.2.3.4")
+	assert.Nil(t, p.failedChunks)
+}
+
+func TestReleaseRedownloads(t *testing.T) {
+	cl, err := NewClient(&TestingConfig)
+	require.NoError(t, err)
+	defer cl.Close()
+	ie := metainfo.InfoEx{
+		Info: metainfo.Info{
+			PieceLength: 2 * defaultChunkSize,
+			Pieces:      make([]byte, 20),
+			Length:      2 * defaultChunkSize,
+		},
+	}
+	ie.UpdateBytes()
+	tt, _, err := cl.AddTorrentSpec(&TorrentSpec{
+		Info:     &ie,
+		InfoHash: ie.Hash(),
+	})
+	require.NoError(t, err)
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	trusted := &connection{}
+	other := &connection{}
+	p := &tt.pieces[0]
+	tt.setRedownloadFrom(0, trusted)
+	assert.False(t, p.mayRequestFrom(other))
+	tt.releaseRedownloads(other)
+	assert.Equal(t, trusted, p.redownloadFrom)
+	tt.releaseRedownloads(trusted)
+	assert.True(t, p.mayRequestFrom(other))
+	assert.True(t, trusted.redownloads.IsEmpty())
+}
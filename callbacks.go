@@ -0,0 +1,168 @@
+package torrent
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sort"
+)
+
+// Functions called as peers come and go and data moves, for monitoring. Any
+// of them may be nil. They're called with the Client lock held, so they
+// must return quickly and must not call methods on the Client or its
+// Torrents.
+type Callbacks struct {
+	// A peer completed handshakes and was added to a torrent.
+	PeerConnected func(PeerInfo)
+	// The peer sent its extension protocol handshake, so its client name
+	// and extension messages are known.
+	PeerExtendedHandshake func(PeerInfo)
+	// The connection to the peer ended. The reason is io.EOF if the peer
+	// closed it, one of the ErrConn errors if we did, or the error that
+	// broke it.
+	PeerDisconnected func(peer PeerInfo, reason error)
+	// A piece was hashed. Suppliers are the IPs of the peers that sent its
+	// chunks since it was last hashed. There are none for data that was
+	// already in storage.
+	PieceHashed func(t *Torrent, piece int, correct bool, suppliers []net.IP)
+	// A chunk arrived from the peer. It's not useful if we didn't want it,
+	// such as when another peer sent it first.
+	ChunkReceived func(peer PeerInfo, chunk ChunkInfo, useful bool)
+	ChunkSent     func(peer PeerInfo, chunk ChunkInfo)
+	// Peers were learned of from a tracker, the DHT, PEX, or
+	// Torrent.AddPeers. Each Peer's Source tells which.
+	PeersDiscovered func(t *Torrent, peers []Peer)
+}
+
+// Reasons given to Callbacks.PeerDisconnected for connections we close.
+var (
+	// Closed to make room for a connection more likely to be useful.
+	ErrConnReplaced = errors.New("replaced by another connection")
+	// Closed because Config.ConnLimits was lowered.
+	ErrConnLimited = errors.New("over connection limit")
+	// The torrent was dropped or the Client closed.
+	ErrConnTorrentClosed = errors.New("torrent closed")
+	// Closed with Torrent.DropPeer.
+	ErrConnDropped = errors.New("dropped")
+	// Closed because the torrent was paused, or queued.
+	ErrConnPaused = errors.New("torrent paused")
+)
+
+// Identifies a connected peer and what it supports.
+type PeerInfo struct {
+	Torrent    *Torrent
+	RemoteAddr net.Addr
+	PeerID     [20]byte
+	// From the peer's extended handshake, if it has sent one.
+	ClientName string
+	// How we learned of the peer.
+	Source    PeerSource
+	Encrypted bool
+	UTP       bool
+	// Flags from the reserved bytes of the BitTorrent handshake.
+	SupportsExtended bool
+	SupportsDHT      bool
+	SupportsFast     bool
+	// The extension protocol messages the peer supports, such as
+	// "ut_metadata" and "ut_pex", sorted.
+	ExtendedMessages []string
+}
+
+// The position of a chunk within the torrent.
+type ChunkInfo struct {
+	Piece  int
+	Begin  int
+	Length int
+}
+
+func (cn *connection) peerInfo() (ret PeerInfo) {
+	ret = PeerInfo{
+		Torrent:          cn.t,
+		PeerID:           cn.PeerID,
+		ClientName:       cn.PeerClientName,
+		Source:           cn.Discovery,
+		Encrypted:        cn.encrypted,
+		UTP:              cn.uTP,
+		SupportsExtended: cn.PeerExtensionBytes.SupportsExtended(),
+		SupportsDHT:      cn.PeerExtensionBytes.SupportsDHT(),
+		SupportsFast:     cn.PeerExtensionBytes.SupportsFast(),
+	}
+	if cn.conn != nil {
+		ret.RemoteAddr = cn.remoteAddr()
+	}
+	for name := range cn.PeerExtensionIDs {
+		ret.ExtendedMessages = append(ret.ExtendedMessages, name)
+	}
+	sort.Strings(ret.ExtendedMessages)
+	return
+}
+
+func chunkInfo(r request) ChunkInfo {
+	return ChunkInfo{int(r.Index), int(r.Begin), int(r.Length)}
+}
+
+// Closes the connection, giving the reason to Callbacks.PeerDisconnected.
+// Only the first reason is kept.
+func (cn *connection) closeWithReason(err error) {
+	if cn.closeReason == nil {
+		cn.closeReason = err
+	}
+	cn.Close()
+}
+
+func (cl *Client) peerConnected(c *connection) {
+	if f := cl.config.Callbacks.PeerConnected; f != nil {
+		f(c.peerInfo())
+	}
+}
+
+func (cl *Client) peerExtendedHandshake(c *connection) {
+	if f := cl.config.Callbacks.PeerExtendedHandshake; f != nil {
+		f(c.peerInfo())
+	}
+}
+
+// err is what ended the connection loop, if anything.
+func (cl *Client) peerDisconnected(c *connection, err error) {
+	f := cl.config.Callbacks.PeerDisconnected
+	if f == nil {
+		return
+	}
+	if err == nil {
+		err = c.closeReason
+	}
+	if err == nil {
+		err = io.EOF
+	}
+	f(c.peerInfo(), err)
+}
+
+func (cl *Client) chunkReceived(c *connection, r request, useful bool) {
+	if f := cl.config.Callbacks.ChunkReceived; f != nil {
+		f(c.peerInfo(), chunkInfo(r), useful)
+	}
+}
+
+func (cl *Client) chunkSent(c *connection, r request) {
+	if f := cl.config.Callbacks.ChunkSent; f != nil {
+		f(c.peerInfo(), chunkInfo(r))
+	}
+}
+
+func (cl *Client) pieceHashedCallback(t *Torrent, piece int, correct bool, suppliers map[string]struct{}) {
+	f := cl.config.Callbacks.PieceHashed
+	if f == nil {
+		return
+	}
+	var ips []net.IP
+	for ip := range suppliers {
+		ips = append(ips, net.ParseIP(ip))
+	}
+	f(t, piece, correct, ips)
+}
+
+func (cl *Client) peersDiscovered(t *Torrent, peers []Peer) {
+	if f := cl.config.Callbacks.PeersDiscovered; f != nil && len(peers) != 0 {
+		f(t, peers)
+	}
+}
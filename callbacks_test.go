@@ -0,0 +1,70 @@
+package torrent
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	pp "github.com/anacrolix/torrent/peer_protocol"
+)
+
+func TestCallbacks(t *testing.T) {
+	var (
+		hashed     []int
+		discovered []Peer
+		reasons    []error
+		received   []ChunkInfo
+	)
+	cfg := TestingConfig
+	// Keeps discovered peers from being dialed.
+	cfg.ConnLimits.MaxHalfOpen = 1
+	cfg.Callbacks = Callbacks{
+		PieceHashed: func(_ *Torrent, piece int, correct bool, suppliers []net.IP) {
+			assert.False(t, correct)
+			assert.Empty(t, suppliers)
+			hashed = append(hashed, piece)
+		},
+		PeersDiscovered: func(_ *Torrent, peers []Peer) {
+			discovered = append(discovered, peers...)
+		},
+		PeerDisconnected: func(_ PeerInfo, reason error) {
+			reasons = append(reasons, reason)
+		},
+		ChunkReceived: func(_ PeerInfo, chunk ChunkInfo, useful bool) {
+			assert.False(t, useful)
+			received = append(received, chunk)
+		},
+	}
+	cl, err := NewClient(&cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt := testTorrentPieces(t, cl, 2)
+	assert.Len(t, hashed, 2)
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	tt.addHalfOpen("1.2.3.4:5")
+	tt.addPeers([]Peer{
+		{IP: net.ParseIP("1.2.3.4"), Port: 6},
+		{IP: net.ParseIP("1.2.3.4"), Port: 0},
+	})
+	require.Len(t, discovered, 1)
+	assert.EqualValues(t, 6, discovered[0].Port)
+	c1, c2 := testAddConn(tt), testAddConn(tt)
+	c1.Discovery = PeerSourcePEX
+	c1.PeerExtensionIDs = map[string]byte{"ut_pex": 1, "ut_metadata": 2}
+	assert.Equal(t, []string{"ut_metadata", "ut_pex"}, c1.peerInfo().ExtendedMessages)
+	assert.Equal(t, PeerSourcePEX, c1.peerInfo().Source)
+	assert.Equal(t, "pex", c1.peerInfo().Source.String())
+	c1.closeWithReason(ErrConnReplaced)
+	c1.closeWithReason(ErrConnTorrentClosed)
+	cl.peerDisconnected(c1, nil)
+	cl.peerDisconnected(c2, nil)
+	assert.Equal(t, []error{ErrConnReplaced, io.EOF}, reasons)
+	// The piece is already being hashed, so the chunk isn't wanted.
+	tt.pieces[0].QueuedForHash = true
+	cl.downloadedChunk(tt, c2, &pp.Message{Index: 0, Begin: 0, Piece: make([]byte, defaultChunkSize)})
+	assert.Equal(t, []ChunkInfo{{0, 0, defaultChunkSize}}, received)
+}
@@ -0,0 +1,227 @@
+package torrent
+
+import (
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// How to pick the peers to upload to when we're seeding, and so have nothing
+// to reciprocate.
+type SeedChokingAlgorithm int
+
+const (
+	// Give each interested peer a turn of seedRotationInterval, favouring
+	// those that have waited longest.
+	SeedChokingRoundRobin SeedChokingAlgorithm = iota
+	// Upload to the peers that take our data the fastest.
+	SeedChokingFastestUpload
+)
+
+const (
+	chokeInterval        = 10 * time.Second
+	optimisticInterval   = 30 * time.Second
+	seedRotationInterval = 30 * time.Second
+	defaultUploadSlots   = 4
+)
+
+// A peer as seen by the choker.
+type chokerPeer struct {
+	// Identifies the peer across calls to choose.
+	id         interface{}
+	interested bool
+	// The peer has pieces we want. Only those that do get regular slots
+	// while we're leeching.
+	hasWanted bool
+	unchoked  bool
+	// When the peer was last unchoked. Zero if never.
+	unchokedAt time.Time
+	// Data received from and sent to the peer since the last choice, in any
+	// consistent unit per unit time.
+	downloadRate float64
+	uploadRate   float64
+}
+
+// Decides which peers of a torrent to upload to. Regular slots go to the
+// peers we download from fastest, or by SeedChokingAlgorithm when seeding.
+// One slot is given to an optimistic unchoke, which rotates every
+// optimisticInterval so that new peers get a chance to reciprocate. Any
+// interested peer can have it, even one with nothing we want yet. The
+// choker doesn't touch connections, so it can be driven directly.
+type choker struct {
+	slots         int
+	seedAlgorithm SeedChokingAlgorithm
+
+	optimistic     interface{}
+	optimisticTime time.Time
+}
+
+// Returns whether each of peers should be unchoked.
+func (me *choker) choose(peers []chokerPeer, seeding bool, now time.Time) (unchoke []bool) {
+	unchoke = make([]bool, len(peers))
+	var candidates, others []int
+	for i, p := range peers {
+		switch {
+		case !p.interested:
+		case seeding || p.hasWanted:
+			candidates = append(candidates, i)
+		default:
+			others = append(others, i)
+		}
+	}
+	regular := me.slots
+	if regular > 1 {
+		regular--
+	}
+	sort.Stable(chokerCandidates{me, peers, candidates, seeding, now})
+	if regular > len(candidates) {
+		regular = len(candidates)
+	}
+	for _, i := range candidates[:regular] {
+		unchoke[i] = true
+	}
+	rest := append(append([]int(nil), candidates[regular:]...), others...)
+	if len(rest) == 0 || regular == me.slots {
+		me.optimistic = nil
+		return
+	}
+	for _, i := range rest {
+		if peers[i].id == me.optimistic && now.Sub(me.optimisticTime) < optimisticInterval {
+			unchoke[i] = true
+			return
+		}
+	}
+	i := rest[rand.Intn(len(rest))]
+	unchoke[i] = true
+	me.optimistic = peers[i].id
+	me.optimisticTime = now
+	return
+}
+
+// Sorts indexes into peers by how much they deserve a regular unchoke.
+type chokerCandidates struct {
+	c       *choker
+	peers   []chokerPeer
+	indexes []int
+	seeding bool
+	now     time.Time
+}
+
+func (me chokerCandidates) Len() int { return len(me.indexes) }
+
+func (me chokerCandidates) Less(i, j int) bool {
+	return me.c.less(me.peers[me.indexes[i]], me.peers[me.indexes[j]], me.seeding, me.now)
+}
+
+func (me chokerCandidates) Swap(i, j int) {
+	me.indexes[i], me.indexes[j] = me.indexes[j], me.indexes[i]
+}
+
+// Returns true if peer a deserves a regular unchoke more than b.
+func (me *choker) less(a, b chokerPeer, seeding bool, now time.Time) bool {
+	if !seeding {
+		if a.downloadRate != b.downloadRate {
+			return a.downloadRate > b.downloadRate
+		}
+		return a.uploadRate > b.uploadRate
+	}
+	switch me.seedAlgorithm {
+	case SeedChokingFastestUpload:
+		return a.uploadRate > b.uploadRate
+	default:
+		ag, bg := seedRotationGroup(a, now), seedRotationGroup(b, now)
+		if ag != bg {
+			return ag < bg
+		}
+		return a.unchokedAt.Before(b.unchokedAt)
+	}
+}
+
+// Peers midway through their turn come first, then those waiting for one,
+// and finally those that have had their turn.
+func seedRotationGroup(p chokerPeer, now time.Time) int {
+	if !p.unchoked {
+		return 1
+	}
+	if now.Sub(p.unchokedAt) < seedRotationInterval {
+		return 0
+	}
+	return 2
+}
+
+func (cl *Client) uploadSlots() int {
+	if cl.config.UploadSlots > 0 {
+		return cl.config.UploadSlots
+	}
+	return defaultUploadSlots
+}
+
+// Periodically reassigns the torrent's upload slots until it's closed.
+func (t *Torrent) chokerLoop() {
+	ticker := time.NewTicker(chokeInterval)
+	defer ticker.Stop()
+	cl := t.cl
+	for {
+		select {
+		case <-ticker.C:
+		case <-t.closed.LockedChan(&cl.mu):
+			return
+		}
+		cl.mu.Lock()
+		t.rechoke(time.Now())
+		cl.mu.Unlock()
+	}
+}
+
+func (t *Torrent) rechoke(now time.Time) {
+	interval := now.Sub(t.lastRechoke).Seconds()
+	if interval <= 0 {
+		interval = chokeInterval.Seconds()
+	}
+	t.lastRechoke = now
+	peers := make([]chokerPeer, 0, len(t.conns))
+	for _, c := range t.conns {
+		peers = append(peers, chokerPeer{
+			id:           c,
+			interested:   !t.cl.config.NoUpload && c.PeerInterested,
+			hasWanted:    t.connHasWantedPieces(c),
+			unchoked:     !c.Choked,
+			unchokedAt:   c.lastUnchoked,
+			downloadRate: float64(c.UsefulChunksReceived-c.rechokeChunksReceived) / interval,
+			uploadRate:   float64(c.chunksSent-c.rechokeChunksSent) / interval,
+		})
+		c.rechokeChunksReceived = c.UsefulChunksReceived
+		c.rechokeChunksSent = c.chunksSent
+	}
+	t.choker.slots = t.cl.uploadSlots()
+	t.choker.seedAlgorithm = t.cl.config.SeedChoking
+	for i, unchoke := range t.choker.choose(peers, t.seeding(), now) {
+		c := t.conns[i]
+		if unchoke {
+			c.Unchoke()
+			t.cl.upload(t, c)
+		} else {
+			c.Choke()
+		}
+	}
+}
+
+// Returns true if the peer qualifies for a regular upload slot. Unless we're
+// seeding, the peer must have something we want in return.
+func (t *Torrent) uploadCandidate(c *connection) bool {
+	if t.cl.config.NoUpload || !c.PeerInterested {
+		return false
+	}
+	return t.seeding() || t.connHasWantedPieces(c)
+}
+
+// Returns true if there's an upload slot that the choker hasn't filled.
+func (t *Torrent) uploadSlotFree() bool {
+	used := 0
+	for _, c := range t.conns {
+		if !c.Choked && c.PeerInterested {
+			used++
+		}
+	}
+	return used < t.cl.uploadSlots()
+}
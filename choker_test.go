@@ -0,0 +1,88 @@
+package torrent
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func countUnchoked(unchoke []bool) (n int) {
+	for _, u := range unchoke {
+		if u {
+			n++
+		}
+	}
+	return
+}
+
+func TestChokerLeechingReciprocates(t *testing.T) {
+	c := choker{slots: 3}
+	now := time.Now()
+	var peers []chokerPeer
+	for i := 0; i < 6; i++ {
+		peers = append(peers, chokerPeer{
+			id:           i,
+			interested:   i != 5,
+			hasWanted:    true,
+			downloadRate: float64(i),
+		})
+	}
+	unchoke := c.choose(peers, false, now)
+	assert.Equal(t, 3, countUnchoked(unchoke))
+	// The two fastest interested peers get the regular slots.
+	assert.True(t, unchoke[4])
+	assert.True(t, unchoke[3])
+	assert.False(t, unchoke[5])
+	optimistic := c.optimistic.(int)
+	assert.True(t, optimistic < 3)
+	assert.True(t, unchoke[optimistic])
+	// The optimistic unchoke is kept until it's due to rotate.
+	unchoke = c.choose(peers, false, now.Add(chokeInterval))
+	assert.True(t, unchoke[optimistic])
+	assert.Equal(t, optimistic, c.optimistic)
+	c.choose(peers, false, now.Add(optimisticInterval))
+	assert.False(t, c.optimisticTime.Equal(now))
+}
+
+func TestChokerSeedingRoundRobin(t *testing.T) {
+	c := choker{slots: 3}
+	now := time.Now()
+	peers := []chokerPeer{
+		// Has had its turn.
+		{id: 0, interested: true, unchoked: true, unchokedAt: now.Add(-time.Minute)},
+		// Midway through its turn.
+		{id: 1, interested: true, unchoked: true, unchokedAt: now.Add(-time.Second)},
+		// Waiting longest for a turn.
+		{id: 2, interested: true, unchokedAt: now.Add(-time.Hour)},
+		{id: 3, interested: true, unchokedAt: now.Add(-time.Minute)},
+	}
+	unchoke := c.choose(peers, true, now)
+	assert.True(t, unchoke[1])
+	assert.True(t, unchoke[2])
+	assert.Equal(t, 3, countUnchoked(unchoke))
+	assert.True(t, unchoke[c.optimistic.(int)])
+}
+
+func TestChokerSeedingFastestUpload(t *testing.T) {
+	c := choker{slots: 1, seedAlgorithm: SeedChokingFastestUpload}
+	peers := []chokerPeer{
+		{id: 0, interested: true, uploadRate: 1},
+		{id: 1, interested: true, uploadRate: 3},
+		{id: 2, interested: true, uploadRate: 2},
+	}
+	assert.Equal(t, []bool{false, true, false}, c.choose(peers, true, time.Now()))
+	assert.Nil(t, c.optimistic)
+}
+
+// A new peer with nothing we want can still be unchoked optimistically.
+func TestChokerOptimisticNewPeer(t *testing.T) {
+	c := choker{slots: 2}
+	peers := []chokerPeer{
+		{id: 0, interested: true, hasWanted: true, downloadRate: 1},
+		{id: 1, interested: true},
+		{id: 2},
+	}
+	assert.Equal(t, []bool{true, true, false}, c.choose(peers, false, time.Now()))
+	assert.Equal(t, 1, c.optimistic)
+}
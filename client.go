@@ -35,15 +35,21 @@ import (
 	"github.com/anacrolix/torrent/storage"
 )
 
-// Currently doesn't really queue, but should in the future.
+// Adds the piece to the Client's hash queue, unless it's already waiting
+// there.
 func (cl *Client) queuePieceCheck(t *Torrent, pieceIndex int) {
 	piece := &t.pieces[pieceIndex]
 	if piece.QueuedForHash {
 		return
 	}
 	piece.QueuedForHash = true
+	t.syncUnrequested(pieceIndex)
 	t.publishPieceChange(pieceIndex)
-	go cl.verifyPiece(t, pieceIndex)
+	cl.hashQueue = append(cl.hashQueue, pieceHashItem{t, pieceIndex})
+	if cl.activeHashers < cl.maxHashers() {
+		cl.activeHashers++
+		go cl.pieceHasher()
+	}
 }
 
 // Queue a piece check if one isn't already queued, and the piece has never
@@ -74,6 +80,8 @@ type Client struct {
 	// through legitimate channels.
 	dopplegangerAddrs map[string]struct{}
 	badPeerIPs        map[string]struct{}
+	// Bad pieces attributed to each IP.
+	peerStrikes map[string]int
 
 	defaultStorage storage.Client
 
@@ -82,6 +90,26 @@ type Client struct {
 	closed missinggo.Event
 
 	torrents map[metainfo.Hash]*Torrent
+
+	// Pieces waiting to be hashed, and the number of goroutines working
+	// through them.
+	hashQueue     []pieceHashItem
+	activeHashers int
+	// Accepted connections that haven't completed handshakes.
+	pendingAccepts int
+	// Connections and dials of all torrents, and the number of torrents
+	// that want connections. See ConnLimits.
+	numConns        int
+	numHalfOpen     int
+	numWantingConns int
+	// Throttles reads for piece hashing. nil if there's no limit.
+	hashLimiter *rateLimiter
+	// Torrents in the order they're started by the queue. See QueueConfig.
+	queue []*Torrent
+	// When the queue was last updated, to count seeding time.
+	queueUpdated time.Time
+	// Values are TorrentEvents for all torrents.
+	torrentEvents *pubsub.PubSub
 }
 
 func (cl *Client) IPBlockList() iplist.Ranger {
@@ -269,6 +297,7 @@ func NewClient(cfg *Config) (cl *Client, err error) {
 		defaultStorage:    cfg.DefaultStorage,
 		dopplegangerAddrs: make(map[string]struct{}),
 		torrents:          make(map[metainfo.Hash]*Torrent),
+		torrentEvents:     pubsub.NewPubSub(),
 	}
 	missinggo.CopyExact(&cl.extensionBytes, defaultExtensionBytes)
 	cl.event.L = &cl.mu
@@ -278,6 +307,9 @@ func NewClient(cfg *Config) (cl *Client, err error) {
 	if cfg.IPBlocklist != nil {
 		cl.ipBlockList = cfg.IPBlocklist
 	}
+	if cfg.HashRateLimit > 0 {
+		cl.hashLimiter = &rateLimiter{rate: cfg.HashRateLimit}
+	}
 
 	if cfg.PeerID != "" {
 		missinggo.CopyExact(&cl.peerID, cfg.PeerID)
@@ -309,6 +341,7 @@ func NewClient(cfg *Config) (cl *Client, err error) {
 	if cl.utpSock != nil {
 		go cl.acceptConnections(cl.utpSock, true)
 	}
+	go cl.queueLoop()
 	if !cfg.NoDHT {
 		dhtCfg := cfg.DHTConfig
 		if dhtCfg.IPBlocklist == nil {
@@ -354,6 +387,7 @@ func (cl *Client) Close() {
 	for _, t := range cl.torrents {
 		t.close()
 	}
+	cl.torrentEvents.Close()
 	cl.event.Broadcast()
 }
 
@@ -381,14 +415,16 @@ func (cl *Client) waitAccept() {
 	cl.mu.Lock()
 	defer cl.mu.Unlock()
 	for {
-		for _, t := range cl.torrents {
-			if cl.wantConns(t) {
-				return
-			}
-		}
 		if cl.closed.IsSet() {
 			return
 		}
+		if !cl.pendingAcceptsFull() {
+			for _, t := range cl.torrents {
+				if cl.wantConns(t) {
+					return
+				}
+			}
+		}
 		cl.event.Wait()
 	}
 }
@@ -425,6 +461,9 @@ func (cl *Client) acceptConnections(l net.Listener, utp bool) {
 			conn.Close()
 			continue
 		}
+		cl.mu.Lock()
+		cl.pendingAccepts++
+		cl.mu.Unlock()
 		go cl.incomingConnection(conn, utp)
 	}
 }
@@ -435,7 +474,7 @@ func (cl *Client) incomingConnection(nc net.Conn, utp bool) {
 		tc.SetLinger(0)
 	}
 	c := cl.newConnection(nc)
-	c.Discovery = peerSourceIncoming
+	c.Discovery = PeerSourceIncoming
 	c.uTP = utp
 	cl.runReceivedConn(c)
 }
@@ -500,7 +539,7 @@ func (cl *Client) initiateConn(peer Peer, t *Torrent) {
 	if t.addrActive(addr) {
 		return
 	}
-	t.halfOpen[addr] = struct{}{}
+	t.addHalfOpen(addr)
 	go cl.outgoingConnection(t, addr, peer.Source)
 }
 
@@ -564,11 +603,8 @@ func (cl *Client) dialFirst(addr string, t *Torrent) (conn net.Conn, utp bool) {
 }
 
 func (cl *Client) noLongerHalfOpen(t *Torrent, addr string) {
-	if _, ok := t.halfOpen[addr]; !ok {
-		panic("invariant broken")
-	}
-	delete(t.halfOpen, addr)
-	cl.openNewConns(t)
+	t.deleteHalfOpen(addr)
+	cl.reopenConns(t)
 }
 
 // Performs initiator handshakes and returns a connection. Returns nil
@@ -627,7 +663,7 @@ func (cl *Client) establishOutgoingConn(t *Torrent, addr string) (c *connection,
 
 // Called to dial out and run a connection. The addr we're given is already
 // considered half-open.
-func (cl *Client) outgoingConnection(t *Torrent, addr string, ps peerSource) {
+func (cl *Client) outgoingConnection(t *Torrent, addr string, ps PeerSource) {
 	c, err := cl.establishOutgoingConn(t, addr)
 	cl.mu.Lock()
 	defer cl.mu.Unlock()
@@ -831,7 +867,10 @@ func maybeReceiveEncryptedHandshake(rw io.ReadWriter, skeys [][]byte) (ret io.Re
 }
 
 func (cl *Client) receiveSkeys() (ret [][]byte) {
-	for ih := range cl.torrents {
+	for ih, t := range cl.torrents {
+		if t.stopped() {
+			continue
+		}
 		ret = append(ret, ih[:])
 	}
 	return
@@ -875,6 +914,9 @@ func (cl *Client) receiveHandshakes(c *connection) (t *Torrent, err error) {
 	}
 	cl.mu.Lock()
 	t = cl.torrents[ih]
+	if t != nil && t.stopped() {
+		t = nil
+	}
 	cl.mu.Unlock()
 	return
 }
@@ -908,6 +950,10 @@ func (cl *Client) runReceivedConn(c *connection) {
 		panic(err)
 	}
 	t, err := cl.receiveHandshakes(c)
+	cl.mu.Lock()
+	cl.pendingAccepts--
+	cl.event.Broadcast()
+	cl.mu.Unlock()
 	if err != nil {
 		if cl.config.Debug {
 			log.Printf("error receiving handshakes: %s", err)
@@ -940,12 +986,14 @@ func (cl *Client) runHandshookConn(c *connection, t *Torrent) {
 		return
 	}
 	defer t.dropConnection(c)
+	cl.peerConnected(c)
 	go c.writer(time.Minute)
 	cl.sendInitialMessages(c, t)
 	err := cl.connectionLoop(t, c)
 	if err != nil && cl.config.Debug {
 		log.Printf("error during connection loop: %s", err)
 	}
+	cl.peerDisconnected(c, err)
 }
 
 func (cl *Client) sendInitialMessages(conn *connection, torrent *Torrent) {
@@ -1019,11 +1067,7 @@ func (cl *Client) connCancel(t *Torrent, cn *connection, r request) (ok bool) {
 }
 
 func (cl *Client) connDeleteRequest(t *Torrent, cn *connection, r request) bool {
-	if !cn.RequestPending(r) {
-		return false
-	}
-	delete(cn.Requests, r)
-	return true
+	return cn.deleteRequest(r)
 }
 
 // Process incoming ut_metadata message.
@@ -1071,40 +1115,35 @@ func (cl *Client) gotMetadataExtensionMsg(payload []byte, t *Torrent, c *connect
 }
 
 func (cl *Client) upload(t *Torrent, c *connection) {
-	if cl.config.NoUpload {
-		return
-	}
-	if !c.PeerInterested {
+	if cl.config.NoUpload || !c.PeerInterested {
 		return
 	}
-	seeding := t.seeding()
-	if !seeding && !t.connHasWantedPieces(c) {
-		return
-	}
-another:
-	for seeding || c.chunksSent < c.UsefulChunksReceived+6 {
+	if c.Choked {
+		// The choker reassigns slots periodically, but there's no need to
+		// leave a free one idle until then.
+		if !t.uploadCandidate(c) || !t.uploadSlotFree() {
+			return
+		}
 		c.Unchoke()
-		for r := range c.PeerRequests {
-			err := cl.sendChunk(t, c, r)
-			if err != nil {
-				if t.pieceComplete(int(r.Index)) && err == io.ErrUnexpectedEOF {
-					// We had the piece, but not anymore.
-				} else {
-					log.Printf("error sending chunk %+v to peer: %s", r, err)
-				}
-				// If we failed to send a chunk, choke the peer to ensure they
-				// flush all their requests. We've probably dropped a piece,
-				// but there's no way to communicate this to the peer. If they
-				// ask for it again, we'll kick them to allow us to send them
-				// an updated bitfield.
-				break another
+	}
+	for r := range c.PeerRequests {
+		err := cl.sendChunk(t, c, r)
+		if err != nil {
+			if t.pieceComplete(int(r.Index)) && err == io.ErrUnexpectedEOF {
+				// We had the piece, but not anymore.
+			} else {
+				log.Printf("error sending chunk %+v to peer: %s", r, err)
 			}
-			delete(c.PeerRequests, r)
-			goto another
+			// If we failed to send a chunk, choke the peer to ensure they
+			// flush all their requests. We've probably dropped a piece, but
+			// there's no way to communicate this to the peer. If they ask for
+			// it again, we'll kick them to allow us to send them an updated
+			// bitfield.
+			c.Choke()
+			return
 		}
-		return
+		delete(c.PeerRequests, r)
 	}
-	c.Choke()
 }
 
 func (cl *Client) sendChunk(t *Torrent, c *connection, r request) error {
@@ -1125,8 +1164,11 @@ func (cl *Client) sendChunk(t *Torrent, c *connection, r request) error {
 		Piece: b,
 	})
 	c.chunksSent++
+	t.uploadedBytes += int64(len(b))
 	uploadChunksPosted.Add(1)
 	c.lastChunkSent = time.Now()
+	c.uploadRate.add(len(b), c.lastChunkSent)
+	cl.chunkSent(c, r)
 	return nil
 }
 
@@ -1157,7 +1199,9 @@ func (cl *Client) connectionLoop(t *Torrent, c *connection) error {
 		switch msg.Type {
 		case pp.Choke:
 			c.PeerChoked = true
-			c.Requests = nil
+			c.deleteAllRequests()
+			// Don't hold pieces for a peer that won't send them.
+			t.releaseRedownloads(c)
 			// We can then reset our interest.
 			c.updateRequests()
 		case pp.Reject:
@@ -1271,6 +1315,7 @@ func (cl *Client) connectionLoop(t *Torrent, c *connection) error {
 				if _, ok := c.PeerExtensionIDs["ut_metadata"]; ok {
 					c.requestPendingMetadata()
 				}
+				cl.peerExtendedHandshake(c)
 			case metadataExtendedId:
 				err = cl.gotMetadataExtensionMsg(msg.ExtendedPayload, t, c)
 				if err != nil {
@@ -1293,7 +1338,7 @@ func (cl *Client) connectionLoop(t *Torrent, c *connection) error {
 							p := Peer{
 								IP:     make([]byte, 4),
 								Port:   cp.Port,
-								Source: peerSourcePEX,
+								Source: PeerSourcePEX,
 							}
 							if i < len(pexMsg.AddedFlags) && pexMsg.AddedFlags[i]&0x01 != 0 {
 								p.SupportsEncryption = true
@@ -1360,13 +1405,24 @@ func (cl *Client) addConnection(t *Torrent, c *connection) bool {
 		if cl.config.Debug && missinggo.CryHeard() {
 			log.Printf("%s: dropping connection to make room for new one:\n    %s", t, c)
 		}
-		c.Close()
+		c.closeWithReason(ErrConnReplaced)
 		t.deleteConnection(c)
 	}
 	if len(t.conns) >= socketsPerTorrent {
 		panic(len(t.conns))
 	}
-	t.conns = append(t.conns, c)
+	if cl.connsFull() {
+		c := cl.connToEvict(t)
+		if c == nil {
+			return false
+		}
+		if cl.config.Debug && missinggo.CryHeard() {
+			log.Printf("%s: dropping connection of %s to make room for new one:\n    %s", t, c.t, c)
+		}
+		c.closeWithReason(ErrConnReplaced)
+		c.t.deleteConnection(c)
+	}
+	t.addConn(c)
 	c.t = t
 	return true
 }
@@ -1385,36 +1441,48 @@ func (cl *Client) usefulConn(t *Torrent, c *connection) bool {
 }
 
 func (cl *Client) wantConns(t *Torrent) bool {
-	if !t.seeding() && !t.needData() {
+	if !t.wantsConns() {
 		return false
 	}
-	if len(t.conns) < socketsPerTorrent {
-		return true
+	if len(t.conns) >= socketsPerTorrent {
+		return t.worstBadConn(cl) != nil
+	}
+	if cl.connsFull() {
+		return cl.connToEvict(t) != nil
 	}
-	return t.worstBadConn(cl) != nil
+	return true
 }
 
 func (cl *Client) openNewConns(t *Torrent) {
 	defer t.updateWantPeersEvent()
-	for len(t.peers) != 0 {
-		if !cl.wantConns(t) {
-			return
-		}
-		if len(t.halfOpen) >= cl.halfOpenLimit {
-			return
-		}
-		var (
-			k peersKey
-			p Peer
-		)
-		for k, p = range t.peers {
-			break
-		}
-		delete(t.peers, k)
-		cl.initiateConn(p, t)
+	for cl.openNewConn(t) {
 	}
 }
 
+// Starts connecting to one of the torrent's peers. Returns false if there
+// are none, or no more connections should be opened.
+func (cl *Client) openNewConn(t *Torrent) bool {
+	if len(t.peers) == 0 {
+		return false
+	}
+	if !cl.wantConns(t) {
+		return false
+	}
+	if len(t.halfOpen) >= cl.halfOpenLimit || cl.halfOpenFull() {
+		return false
+	}
+	var (
+		k peersKey
+		p Peer
+	)
+	for k, p = range t.peers {
+		break
+	}
+	delete(t.peers, k)
+	cl.initiateConn(p, t)
+	return true
+}
+
 func (cl *Client) badPeerIPPort(ip net.IP, port int) bool {
 	if port == 0 {
 		return true
@@ -1443,6 +1511,7 @@ func (cl *Client) newTorrent(ih metainfo.Hash) (t *Torrent) {
 
 		halfOpen:          make(map[string]struct{}),
 		pieceStateChanges: pubsub.NewPubSub(),
+		events:            pubsub.NewPubSub(),
 
 		storageOpener: cl.defaultStorage,
 	}
@@ -1524,10 +1593,18 @@ func (cl *Client) AddTorrentInfoHash(infoHash metainfo.Hash) (t *Torrent, new bo
 	}
 	new = true
 	t = cl.newTorrent(infoHash)
+	// Waits for a turn before doing anything.
+	t.queued = cl.queueing()
 	if cl.dHT != nil {
 		go t.announceDHT(true)
 	}
+	go t.chokerLoop()
+	go t.requestTimeoutLoop()
 	cl.torrents[infoHash] = t
+	t.publishEvent(TorrentEvent{Type: TorrentAdded})
+	cl.queue = append(cl.queue, t)
+	cl.updateQueue(time.Now())
+	t.updateWantsConns()
 	t.updateWantPeersEvent()
 	return
 }
@@ -1550,7 +1627,7 @@ func (cl *Client) AddTorrentSpec(spec *TorrentSpec) (t *Torrent, new bool, err e
 	cl.mu.Lock()
 	defer cl.mu.Unlock()
 	if spec.ChunkSize != 0 {
-		t.chunkSize = pp.Integer(spec.ChunkSize)
+		t.setChunkSize(pp.Integer(spec.ChunkSize))
 	}
 	t.addTrackers(spec.Trackers)
 	t.maybeNewConns()
@@ -1567,7 +1644,10 @@ func (cl *Client) dropTorrent(infoHash metainfo.Hash) (err error) {
 	if err != nil {
 		panic(err)
 	}
+	cl.forgetConns(t)
 	delete(cl.torrents, infoHash)
+	cl.removeFromQueue(t)
+	cl.updateQueue(time.Now())
 	return
 }
 
@@ -1626,6 +1706,7 @@ func (cl *Client) downloadedChunk(t *Torrent, c *connection, msg *pp.Message) {
 	chunksReceived.Add(1)
 
 	req := newRequest(msg.Index, msg.Begin, pp.Integer(len(msg.Piece)))
+	c.measureChunk(req, len(msg.Piece), time.Now())
 
 	// Request has been satisfied.
 	if cl.connDeleteRequest(t, c, req) {
@@ -1641,19 +1722,27 @@ func (cl *Client) downloadedChunk(t *Torrent, c *connection, msg *pp.Message) {
 	if !t.wantPiece(req) {
 		unwantedChunksReceived.Add(1)
 		c.UnwantedChunksReceived++
+		chunkBytesWasted.Add(int64(len(msg.Piece)))
+		t.wastedBytes += int64(len(msg.Piece))
+		cl.chunkReceived(c, req, false)
 		return
 	}
 
 	c.UsefulChunksReceived++
 	c.lastUsefulChunkReceived = time.Now()
+	cl.chunkReceived(c, req, true)
 
 	cl.upload(t, c)
 
 	// Need to record that it hasn't been written yet, before we attempt to do
 	// anything with it.
 	piece.incrementPendingWrites()
-	// Record that we have the chunk.
+	// Record that we have the chunk, and who it came from.
 	piece.unpendChunkIndex(chunkIndex(req.chunkSpec, t.chunkSize))
+	if piece.chunkSuppliers == nil {
+		piece.chunkSuppliers = make(map[int]string)
+	}
+	piece.chunkSuppliers[chunkIndex(req.chunkSpec, t.chunkSize)] = connIP(c)
 
 	// Cancel pending requests for this chunk.
 	for _, c := range t.conns {
@@ -1672,10 +1761,13 @@ func (cl *Client) downloadedChunk(t *Torrent, c *connection, msg *pp.Message) {
 
 	if err != nil {
 		log.Printf("%s: error writing chunk %v: %s", t, req, err)
+		t.setStorageErr(err)
 		t.pendRequest(req)
 		t.updatePieceCompletion(int(msg.Index))
 		return
 	}
+	t.setStorageErr(nil)
+	t.madeProgress()
 
 	// It's important that the piece is potentially queued before we check if
 	// the piece is still wanted, because if it is queued, it won't be wanted.
@@ -1705,7 +1797,9 @@ func (cl *Client) reapPieceTouches(t *Torrent, piece int) (ret []*connection) {
 	return
 }
 
-func (cl *Client) pieceHashed(t *Torrent, piece int, correct bool) {
+// chunkSums are the hashes of each chunk of the piece data, if they were
+// wanted for blame.
+func (cl *Client) pieceHashed(t *Torrent, piece int, correct bool, chunkSums []metainfo.Hash) {
 	if t.closed.IsSet() {
 		return
 	}
@@ -1721,29 +1815,49 @@ func (cl *Client) pieceHashed(t *Torrent, piece int, correct bool) {
 		}
 	}
 	p.EverHashed = true
+	suppliers := p.suppliers()
 	touchers := cl.reapPieceTouches(t, piece)
 	if correct {
 		for _, c := range touchers {
 			c.goodPiecesDirtied++
 		}
-		err := p.Storage().MarkComplete()
+		t.pieceHashPassed(piece, chunkSums)
+		// A recheck passing a complete piece leaves nothing for storage to
+		// do.
+		var err error
+		if !t.pieceComplete(piece) {
+			err = p.Storage().MarkComplete()
+			if err != nil {
+				log.Printf("%T: error completing piece %d: %s", t.storage, piece, err)
+			}
+		}
+		// Success clears any earlier error, so a complete torrent doesn't
+		// stay in TorrentError.
+		t.setStorageErr(err)
+		t.updatePieceCompletion(piece)
+	} else if t.pieceComplete(piece) {
+		// Data we previously had has gone bad, probably through a recheck.
+		err := p.Storage().MarkNotComplete()
 		if err != nil {
-			log.Printf("%T: error completing piece %d: %s", t.storage, piece, err)
+			log.Printf("%T: error marking piece %d not complete: %s", t.storage, piece, err)
 		}
 		t.updatePieceCompletion(piece)
 	} else if len(touchers) != 0 {
-		log.Printf("dropping and banning %d conns that touched piece", len(touchers))
 		for _, c := range touchers {
 			c.badPiecesDirtied++
-			t.cl.banPeerIP(missinggo.AddrIP(c.remoteAddr()))
-			t.dropConnection(c)
 		}
+		t.pieceHashFailed(piece, chunkSums)
 	}
+	cl.pieceHashedCallback(t, piece, correct, suppliers)
 	cl.pieceChanged(t, piece)
 }
 
 func (cl *Client) onCompletedPiece(t *Torrent, piece int) {
 	t.pendingPieces.Remove(piece)
+	t.pieces[piece].deadline = time.Time{}
+	t.deadlinePieces.Remove(piece)
+	t.pieces[piece].readerDeadline = time.Time{}
+	t.readerDeadlinePieces.Remove(piece)
 	t.pendAllChunkSpecs(piece)
 	for _, conn := range t.conns {
 		conn.Have(piece)
@@ -1787,26 +1901,46 @@ func (cl *Client) pieceChanged(t *Torrent, piece int) {
 	t.publishPieceChange(piece)
 }
 
+// Hashes the piece and handles the result. Must be called with the Client
+// lock held, which is released while the piece data is read.
 func (cl *Client) verifyPiece(t *Torrent, piece int) {
-	cl.mu.Lock()
-	defer cl.mu.Unlock()
 	p := &t.pieces[piece]
 	for p.Hashing || t.storage == nil {
 		cl.event.Wait()
 	}
 	p.QueuedForHash = false
-	if t.closed.IsSet() || t.pieceComplete(piece) {
-		t.updatePiecePriority(piece)
+	if t.closed.IsSet() {
 		t.publishPieceChange(piece)
 		return
 	}
 	p.Hashing = true
+	t.syncUnrequested(piece)
 	t.publishPieceChange(piece)
+	// If we haven't written to the piece since it was last checked, holes in
+	// its storage mean it can't be complete. Data we've just written may not
+	// have reached the storage yet, so we don't trust holes there.
+	trustHoles := !p.hasDirtyChunks()
+	var chunkSize pp.Integer
+	if p.wantChunkSums() {
+		chunkSize = t.chunkSize
+	}
 	cl.mu.Unlock()
-	sum := t.hashPiece(piece)
+	var (
+		sum       metainfo.Hash
+		chunkSums []metainfo.Hash
+	)
+	if !trustHoles || !t.pieceHasHoles(piece) {
+		sum, chunkSums = t.hashPiece(piece, chunkSize)
+	}
 	cl.mu.Lock()
 	p.Hashing = false
-	cl.pieceHashed(t, piece, sum == p.Hash)
+	t.syncUnrequested(piece)
+	p.numVerifies++
+	if p.checking {
+		p.checking = false
+		t.numCheckingPieces--
+	}
+	cl.pieceHashed(t, piece, sum == p.Hash, chunkSums)
 }
 
 // Returns handles to all the torrents loaded in the Client.
@@ -10,6 +10,7 @@ import (
 	"math/rand"
 	"net"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"testing"
@@ -88,11 +89,12 @@ func TestTorrentInitialState(t *testing.T) {
 	tor := &Torrent{
 		infoHash:          mi.Info.Hash(),
 		pieceStateChanges: pubsub.NewPubSub(),
+		events:            pubsub.NewPubSub(),
 	}
 	tor.chunkSize = 2
 	tor.storageOpener = storage.NewFile("/dev/null")
 	// Needed to lock for asynchronous piece verification.
-	tor.cl = new(Client)
+	tor.cl = &Client{torrentEvents: pubsub.NewPubSub()}
 	err := tor.setInfoBytes(mi.Info.Bytes)
 	require.NoError(t, err)
 	require.Len(t, tor.pieces, 3)
@@ -372,6 +374,40 @@ func testClientTransfer(t *testing.T, ps testClientTransferParams) {
 	}
 }
 
+func TestTorrentBytesUploaded(t *testing.T) {
+	greetingTempDir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(greetingTempDir)
+	cfg := TestingConfig
+	cfg.Seed = true
+	cfg.DataDir = greetingTempDir
+	seeder, err := NewClient(&cfg)
+	require.NoError(t, err)
+	defer seeder.Close()
+	seederTorrent, _, err := seeder.AddTorrentSpec(TorrentSpecFromMetaInfo(mi))
+	require.NoError(t, err)
+	assert.EqualValues(t, 0, seederTorrent.BytesUploaded())
+	leecherDataDir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(leecherDataDir)
+	cfg.DataDir = leecherDataDir
+	leecher, err := NewClient(&cfg)
+	require.NoError(t, err)
+	defer leecher.Close()
+	leecherTorrent, _, err := leecher.AddTorrentSpec(TorrentSpecFromMetaInfo(mi))
+	require.NoError(t, err)
+	leecherTorrent.AddPeers([]Peer{{
+		IP:   missinggo.AddrIP(seeder.ListenAddr()),
+		Port: missinggo.AddrPort(seeder.ListenAddr()),
+	}})
+	r := leecherTorrent.NewReader()
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.EqualValues(t, testutil.GreetingFileContents, b)
+	assert.EqualValues(t, len(testutil.GreetingFileContents), seederTorrent.BytesUploaded())
+	assert.EqualValues(t, 0, leecherTorrent.BytesUploaded())
+}
+
 // Check that after completing leeching, a leecher transitions to a seeding
 // correctly. Connected in a chain like so: Seeder <-> Leecher <-> LeecherLeecher.
 func TestSeedAfterDownloading(t *testing.T) {
@@ -489,6 +525,10 @@ func (p badStoragePiece) MarkComplete() error {
 	return errors.New("psyyyyyyyche")
 }
 
+func (p badStoragePiece) MarkNotComplete() error {
+	return nil
+}
+
 func (p badStoragePiece) randomlyTruncatedDataString() string {
 	return "hello, world\n"[:rand.Intn(14)]
 }
@@ -938,3 +978,114 @@ func TestClientDynamicListenPortNoProtocols(t *testing.T) {
 	t.Log(cl.listenAddr)
 	assert.Nil(t, cl.ListenAddr())
 }
+
+// Records the errors from marking pieces complete.
+type markCompleteErrs struct {
+	storage.Client
+	mu   sync.Mutex
+	errs []error
+}
+
+func (me *markCompleteErrs) OpenTorrent(info *metainfo.InfoEx) (storage.Torrent, error) {
+	t, err := me.Client.OpenTorrent(info)
+	return markCompleteErrsTorrent{t, me}, err
+}
+
+type markCompleteErrsTorrent struct {
+	storage.Torrent
+	m *markCompleteErrs
+}
+
+func (me markCompleteErrsTorrent) Piece(p metainfo.Piece) storage.Piece {
+	return markCompleteErrsPiece{me.Torrent.Piece(p), me.m}
+}
+
+type markCompleteErrsPiece struct {
+	storage.Piece
+	m *markCompleteErrs
+}
+
+func (me markCompleteErrsPiece) MarkComplete() error {
+	err := me.Piece.MarkComplete()
+	if err != nil {
+		me.m.mu.Lock()
+		me.m.errs = append(me.m.errs, err)
+		me.m.mu.Unlock()
+	}
+	return err
+}
+
+// Rechecking complete data shouldn't have storage complete the pieces
+// again, which fails for storage that moves piece data when it's completed.
+func TestVerifyDataCompleteStorage(t *testing.T) {
+	for _, newStorage := range []func(dir string) storage.Client{
+		func(dir string) storage.Client {
+			fc, err := filecache.NewCache(dir)
+			require.NoError(t, err)
+			return fileCachePieceFileStorage(fc)
+		},
+		func(dir string) storage.Client {
+			return storage.NewBlobPieces(storage.NewDirBlobStore(filepath.Join(dir, "blobs")), filepath.Join(dir, "incomplete"))
+		},
+	} {
+		testVerifyDataCompleteStorage(t, newStorage)
+	}
+}
+
+func testVerifyDataCompleteStorage(t *testing.T, newStorage func(dir string) storage.Client) {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	mi := testutil.GreetingMetaInfo()
+	ts, err := newStorage(dir).OpenTorrent(&mi.Info)
+	require.NoError(t, err)
+	for i := range iter.N(mi.Info.NumPieces()) {
+		p := mi.Info.Piece(i)
+		sp := ts.Piece(p)
+		_, err := sp.WriteAt([]byte(testutil.GreetingFileContents)[p.Offset():p.Offset()+p.Length()], 0)
+		require.NoError(t, err)
+		require.NoError(t, sp.MarkComplete())
+	}
+	ts.Close()
+	mce := &markCompleteErrs{Client: newStorage(dir)}
+	cfg := TestingConfig
+	cfg.DefaultStorage = mce
+	cl, err := NewClient(&cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, err := cl.AddTorrent(mi)
+	require.NoError(t, err)
+	tt.VerifyData()
+	for i := range iter.N(tt.NumPieces()) {
+		assert.True(t, tt.PieceState(i).Complete)
+	}
+	assert.Empty(t, mce.errs)
+	assert.NoError(t, tt.StorageErr())
+}
+
+func TestVerifyDataDetectsCorruption(t *testing.T) {
+	greetingTempDir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(greetingTempDir)
+	cfg := TestingConfig
+	cfg.DataDir = greetingTempDir
+	cfg.HashWorkers = 2
+	cl, err := NewClient(&cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, err := cl.AddTorrent(mi)
+	require.NoError(t, err)
+	tt.VerifyData()
+	for i := range iter.N(tt.NumPieces()) {
+		assert.True(t, tt.PieceState(i).Complete)
+	}
+	f, err := os.OpenFile(filepath.Join(greetingTempDir, testutil.GreetingFileName), os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("j"), 0)
+	f.Close()
+	require.NoError(t, err)
+	tt.VerifyData()
+	assert.False(t, tt.PieceState(0).Complete)
+	for i := 1; i < tt.NumPieces(); i++ {
+		assert.True(t, tt.PieceState(i).Complete)
+	}
+}
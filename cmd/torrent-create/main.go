@@ -1,8 +1,10 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 
 	"github.com/anacrolix/tagflag"
 
@@ -20,22 +22,76 @@ var (
 func main() {
 	log.SetFlags(log.Flags() | log.Lshortfile)
 	var args struct {
-		AnnounceList []string `name:"a" help:"extra announce-list tier entry"`
+		AnnounceList      []string `name:"a" help:"extra announce-list tier entry"`
+		NoBuiltinAnnounce bool     `help:"don't include the builtin announce-list"`
+		PieceLength       int64    `help:"piece length in bytes, chosen from the total size if not given"`
+		Private           bool     `help:"set the private flag"`
+		Pad               bool     `help:"align files to piece boundaries with BEP 47 pad files"`
+		Symlinks          string   `help:"symlink handling: follow, skip or keep"`
+		Exclude           []string `help:"glob pattern matched against file names to exclude"`
+		WebSeed           []string `name:"w" help:"web seed URL"`
+		Node              []string `name:"n" help:"DHT node as host:port"`
+		Workers           int      `help:"number of pieces to hash concurrently"`
+		Progress          bool     `help:"show hashing progress on stderr"`
 		tagflag.StartPos
 		Root string
 	}
 	tagflag.Parse(&args, tagflag.Description("Creates a torrent metainfo for the file system rooted at ROOT, and outputs it to stdout."))
-	mi := metainfo.MetaInfo{
-		AnnounceList: builtinAnnounceList,
+	mi := metainfo.MetaInfo{}
+	if !args.NoBuiltinAnnounce {
+		mi.AnnounceList = builtinAnnounceList
 	}
 	for _, a := range args.AnnounceList {
 		mi.AnnounceList = append(mi.AnnounceList, []string{a})
 	}
 	mi.SetDefaults()
-	err := mi.Info.BuildFromFilePath(args.Root)
+	switch len(args.WebSeed) {
+	case 0:
+	case 1:
+		mi.URLList = args.WebSeed[0]
+	default:
+		mi.URLList = args.WebSeed
+	}
+	for _, n := range args.Node {
+		mi.Nodes = append(mi.Nodes, metainfo.Node(n))
+	}
+	b := metainfo.Builder{
+		PieceLength: args.PieceLength,
+		HashWorkers: args.Workers,
+		Private:     args.Private,
+		PadFiles:    args.Pad,
+		Filter: func(path string, fi os.FileInfo) bool {
+			for _, pattern := range args.Exclude {
+				if ok, _ := filepath.Match(pattern, fi.Name()); ok {
+					return false
+				}
+			}
+			return true
+		},
+	}
+	switch args.Symlinks {
+	case "", "follow":
+		b.Symlinks = metainfo.SymlinksFollow
+	case "skip":
+		b.Symlinks = metainfo.SymlinksSkip
+	case "keep":
+		b.Symlinks = metainfo.SymlinksKeep
+	default:
+		log.Fatalf("unknown symlink policy: %q", args.Symlinks)
+	}
+	if args.Progress {
+		b.Progress = func(hashed, total int) {
+			fmt.Fprintf(os.Stderr, "\rhashed %d/%d pieces", hashed, total)
+			if hashed == total {
+				fmt.Fprintln(os.Stderr)
+			}
+		}
+	}
+	info, err := b.Build(args.Root)
 	if err != nil {
 		log.Fatal(err)
 	}
+	mi.Info.Info = *info
 	err = mi.Write(os.Stdout)
 	if err != nil {
 		log.Fatal(err)
@@ -0,0 +1,172 @@
+// Manages a Client through its HTTP API.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/anacrolix/tagflag"
+
+	"github.com/anacrolix/torrent/httpapi"
+)
+
+var args = struct {
+	Addr string `help:"base URL of the API"`
+	tagflag.StartPos
+	Command string
+	Args    []string `arity:"*"`
+}{
+	Addr: "http://localhost:6880",
+}
+
+const description = `Manages a Client through its HTTP API. COMMAND is one of:
+
+	list                          list the torrents
+	add <file|magnet|url>...      add torrents
+	show <infohash>               show a torrent and its files
+	drop <infohash>               drop a torrent
+	pause <infohash>              stop a torrent without dropping it
+	resume <infohash>             restart a paused torrent
+	queue <infohash> <position>   move a torrent in the queue
+	peers <infohash>              list a torrent's peers
+	trackers <infohash>           list a torrent's trackers
+	priority <infohash> <file index> <default|skip|low|normal|high>
+	                              set a file's priority
+	settings [json]               show, or change the settings given in a JSON object
+	events                        print events as they occur
+
+The API's token, if it requires one, is taken from $TORRENT_API_TOKEN.`
+
+func printJSON(v interface{}) {
+	b, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Stdout.Write(append(b, '\n'))
+}
+
+func wantArgs(n int) {
+	if len(args.Args) != n {
+		log.Fatalf("%s expects %d arguments", args.Command, n)
+	}
+}
+
+func add(c *httpapi.Client, arg string) (httpapi.Torrent, error) {
+	switch {
+	case strings.HasPrefix(arg, "magnet:"):
+		return c.AddTorrent(httpapi.AddRequest{Magnet: arg})
+	case strings.HasPrefix(arg, "http://"), strings.HasPrefix(arg, "https://"):
+		return c.AddTorrent(httpapi.AddRequest{URL: arg})
+	}
+	f, err := os.Open(arg)
+	if err != nil {
+		return httpapi.Torrent{}, err
+	}
+	defer f.Close()
+	return c.AddMetainfo(f)
+}
+
+func main() {
+	log.SetFlags(0)
+	tagflag.Parse(&args, tagflag.Description(description))
+	c := &httpapi.Client{
+		URL:   args.Addr,
+		Token: os.Getenv("TORRENT_API_TOKEN"),
+	}
+	var err error
+	switch args.Command {
+	case "list":
+		var ts []httpapi.Torrent
+		ts, err = c.Torrents()
+		for _, t := range ts {
+			progress := "?"
+			if t.HaveInfo && t.Length != 0 {
+				progress = fmt.Sprintf("%d%%", 100*t.BytesCompleted/t.Length)
+			}
+			fmt.Printf("%s\t%s\t%s\t%s\n", t.InfoHash, progress, t.State, t.Name)
+		}
+	case "add":
+		for _, arg := range args.Args {
+			var t httpapi.Torrent
+			t, err = add(c, arg)
+			if err != nil {
+				log.Printf("error adding %q: %s", arg, err)
+				continue
+			}
+			fmt.Printf("%s\t%s\n", t.InfoHash, t.Name)
+		}
+	case "show":
+		wantArgs(1)
+		var t httpapi.Torrent
+		t, err = c.Torrent(args.Args[0])
+		if err == nil {
+			printJSON(t)
+		}
+	case "drop":
+		wantArgs(1)
+		err = c.Drop(args.Args[0])
+	case "pause":
+		wantArgs(1)
+		err = c.Pause(args.Args[0])
+	case "resume":
+		wantArgs(1)
+		err = c.Resume(args.Args[0])
+	case "queue":
+		wantArgs(2)
+		pos, convErr := strconv.Atoi(args.Args[1])
+		if convErr != nil {
+			log.Fatalf("bad queue position: %s", convErr)
+		}
+		err = c.SetQueuePosition(args.Args[0], pos)
+	case "peers":
+		wantArgs(1)
+		var peers []httpapi.Peer
+		peers, err = c.Peers(args.Args[0])
+		if err == nil {
+			printJSON(peers)
+		}
+	case "trackers":
+		wantArgs(1)
+		var trackers [][]string
+		trackers, err = c.Trackers(args.Args[0])
+		if err == nil {
+			printJSON(trackers)
+		}
+	case "priority":
+		wantArgs(3)
+		index, convErr := strconv.Atoi(args.Args[1])
+		if convErr != nil {
+			log.Fatalf("bad file index: %s", convErr)
+		}
+		err = c.SetFilePriority(args.Args[0], index, args.Args[2])
+	case "settings":
+		var s httpapi.Settings
+		if len(args.Args) == 0 {
+			s, err = c.Settings()
+		} else {
+			wantArgs(1)
+			var changes map[string]interface{}
+			if err := json.Unmarshal([]byte(args.Args[0]), &changes); err != nil {
+				log.Fatalf("error parsing settings: %s", err)
+			}
+			s, err = c.SetSettings(changes)
+		}
+		if err == nil {
+			printJSON(s)
+		}
+	case "events":
+		enc := json.NewEncoder(os.Stdout)
+		err = c.Events(func(ev httpapi.Event) error {
+			return enc.Encode(ev)
+		})
+	default:
+		log.Fatalf("unknown command %q", args.Command)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
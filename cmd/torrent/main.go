@@ -16,6 +16,7 @@ import (
 	"github.com/gosuri/uiprogress"
 
 	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/httpapi"
 	"github.com/anacrolix/torrent/metainfo"
 	"github.com/anacrolix/torrent/storage"
 )
@@ -39,17 +40,11 @@ func torrentBar(t *torrent.Torrent) {
 	bar := uiprogress.AddBar(1)
 	bar.AppendCompleted()
 	bar.AppendFunc(func(*uiprogress.Bar) (ret string) {
-		select {
-		case <-t.GotInfo():
+		switch s := t.State(); s {
+		case torrent.TorrentDownloading, torrent.TorrentStalled:
+			return fmt.Sprintf("%s (%s/%s)", s, humanize.Bytes(uint64(t.BytesCompleted())), humanize.Bytes(uint64(t.Info().TotalLength())))
 		default:
-			return "getting info"
-		}
-		if t.Seeding() {
-			return "seeding"
-		} else if t.BytesCompleted() == t.Info().TotalLength() {
-			return "completed"
-		} else {
-			return fmt.Sprintf("downloading (%s/%s)", humanize.Bytes(uint64(t.BytesCompleted())), humanize.Bytes(uint64(t.Info().TotalLength())))
+			return s.String()
 		}
 	})
 	bar.PrependFunc(func(*uiprogress.Bar) string {
@@ -107,23 +102,39 @@ func addTorrents(client *torrent.Client) {
 
 var flags struct {
 	Mmap     bool           `help:"memory-map torrent data"`
+	DataDir  string         `help:"where torrent data is stored, default the working directory"`
 	TestPeer []*net.TCPAddr `help:"addresses of some starting peers"`
 	Seed     bool           `help:"seed after download is complete"`
 	Addr     *net.TCPAddr   `help:"network listen addr"`
+	APIAddr  string         `name:"api" help:"serve the HTTP management API on this address, on localhost if no host is given, see torrent-remote. Anyone who can reach it controls the client, unless the token in $TORRENT_API_TOKEN is required"`
+
+	Watch      string        `help:"run as a daemon adding the .torrent and .magnet files put in this directory, instead of taking torrents as arguments"`
+	Processing string        `help:"where watched files are moved while their torrents download, default processing in the watched directory"`
+	Done       string        `help:"where watched files are moved when their torrents complete, default done in the watched directory"`
+	OnComplete string        `help:"shell command run when a watched torrent completes, with TORRENT_NAME, TORRENT_INFOHASH and TORRENT_DATA set"`
+	MoveTo     string        `help:"directory to move the data of watched torrents to when they stop seeding"`
+	SeedRatio  float64       `help:"stop seeding watched torrents after uploading this multiple of their length"`
+	SeedTime   time.Duration `help:"stop seeding watched torrents after this long"`
+
 	tagflag.StartPos
-	Torrent []string `arity:"+" help:"torrent file path or magnet uri"`
+	Torrent []string `arity:"*" help:"torrent file path or magnet uri"`
 }
 
 func main() {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 	tagflag.Parse(&flags)
 	var clientConfig torrent.Config
+	clientConfig.DataDir = flags.DataDir
 	if flags.Mmap {
-		clientConfig.DefaultStorage = storage.NewMMap("")
+		clientConfig.DefaultStorage = storage.NewMMap(flags.DataDir)
 	}
 	if flags.Addr != nil {
 		clientConfig.ListenAddr = flags.Addr.String()
 	}
+	clientConfig.Seed = flags.Seed || flags.SeedRatio != 0 || flags.SeedTime != 0
+	if (flags.Watch == "") == (len(flags.Torrent) == 0) {
+		log.Fatal("expected torrents, or a directory to watch")
+	}
 
 	client, err := torrent.NewClient(&clientConfig)
 	if err != nil {
@@ -136,6 +147,19 @@ func main() {
 	http.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
 		client.WriteStatus(w)
 	})
+	if flags.APIAddr != "" {
+		h := &httpapi.Handler{
+			Client: client,
+			Token:  os.Getenv("TORRENT_API_TOKEN"),
+		}
+		go func() {
+			log.Fatal(http.ListenAndServe(apiListenAddr(flags.APIAddr), h))
+		}()
+	}
+	if flags.Watch != "" {
+		watchDir(client)
+		return
+	}
 	uiprogress.Start()
 	addTorrents(client)
 	if client.WaitAll() {
@@ -147,3 +171,13 @@ func main() {
 		select {}
 	}
 }
+
+// Serves the API on localhost if the address has no host, rather than on
+// all interfaces.
+func apiListenAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host != "" {
+		return addr
+	}
+	return net.JoinHostPort("localhost", port)
+}
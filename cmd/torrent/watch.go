@@ -0,0 +1,206 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/util/dirwatch"
+)
+
+// Adds the torrents for files dropped into a directory, and moves the files
+// along as their torrents progress: to the processing directory when they're
+// added, and to the done directory when all the torrents from a file are
+// complete.
+type watcher struct {
+	client     *torrent.Client
+	processing string
+	done       string
+	// Where the client stores torrent data.
+	dataDir string
+
+	mu sync.Mutex
+	// The number of incomplete torrents from each file in processing.
+	pending map[string]int
+	// Tracks the torrentAdded goroutines.
+	wg sync.WaitGroup
+}
+
+func watchDir(client *torrent.Client) {
+	w := &watcher{
+		client:     client,
+		processing: flags.Processing,
+		done:       flags.Done,
+		dataDir:    flags.DataDir,
+		pending:    make(map[string]int),
+	}
+	if w.processing == "" {
+		w.processing = filepath.Join(flags.Watch, "processing")
+	}
+	if w.done == "" {
+		w.done = filepath.Join(flags.Watch, "done")
+	}
+	for _, dir := range []string{w.processing, w.done} {
+		if err := os.MkdirAll(dir, 0750); err != nil {
+			log.Fatal(err)
+		}
+	}
+	w.requeue()
+	dw, err := dirwatch.New(flags.Watch)
+	if err != nil {
+		log.Fatalf("error watching %q: %s", flags.Watch, err)
+	}
+	defer dw.Close()
+	for ev := range dw.Events {
+		// Files are removed from the watched directory when they're moved to
+		// processing.
+		if ev.Change != dirwatch.Added {
+			continue
+		}
+		w.add(ev)
+	}
+}
+
+// Returns files left in processing by a previous run to the watched
+// directory, so they're added again.
+func (w *watcher) requeue() {
+	names, err := filepath.Glob(filepath.Join(w.processing, "*"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, name := range names {
+		err := os.Rename(name, filepath.Join(flags.Watch, filepath.Base(name)))
+		if err != nil {
+			log.Print(err)
+		}
+	}
+}
+
+func (w *watcher) add(ev dirwatch.Event) {
+	var (
+		t    *torrent.Torrent
+		err  error
+		file string
+	)
+	if ev.TorrentFilePath != "" {
+		file = ev.TorrentFilePath
+		t, err = w.client.AddTorrentFromFile(file)
+	} else {
+		file = ev.MagnetFilePath
+		t, err = w.client.AddMagnet(ev.MagnetURI)
+	}
+	if err != nil {
+		log.Printf("error adding torrent from %q: %s", file, err)
+		return
+	}
+	processing := filepath.Join(w.processing, filepath.Base(file))
+	w.mu.Lock()
+	// A magnet file can have several links, and it's moved for the first.
+	if w.pending[processing] == 0 {
+		if err := os.Rename(file, processing); err != nil {
+			log.Print(err)
+		}
+	}
+	w.pending[processing]++
+	w.mu.Unlock()
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		w.torrentAdded(t, processing)
+	}()
+}
+
+func (w *watcher) fileTorrentCompleted(processing string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pending[processing]--
+	if w.pending[processing] != 0 {
+		return
+	}
+	delete(w.pending, processing)
+	err := os.Rename(processing, filepath.Join(w.done, filepath.Base(processing)))
+	if err != nil {
+		log.Print(err)
+	}
+}
+
+// Follows a torrent from a watched file until it's complete, and then until
+// it's done seeding. Returns early if the torrent is dropped.
+func (w *watcher) torrentAdded(t *torrent.Torrent, processing string) {
+	select {
+	case <-t.GotInfo():
+	case <-t.Closed():
+		return
+	}
+	t.DownloadAll()
+	select {
+	case <-t.Complete():
+	case <-t.Closed():
+		return
+	}
+	log.Printf("completed %q", t.Name())
+	w.fileTorrentCompleted(processing)
+	if flags.OnComplete != "" {
+		w.runCompletionHook(t)
+	}
+	if !seedUntilLimit(t) {
+		return
+	}
+	t.Drop()
+	if flags.MoveTo != "" {
+		dest := filepath.Join(flags.MoveTo, t.Info().Name)
+		if err := os.Rename(w.dataPath(t), dest); err != nil {
+			log.Printf("error moving data for %q: %s", t.Name(), err)
+		}
+	}
+}
+
+// The path to the torrent's file, or directory of files.
+func (w *watcher) dataPath(t *torrent.Torrent) string {
+	return filepath.Join(w.dataDir, t.Info().Name)
+}
+
+func (w *watcher) runCompletionHook(t *torrent.Torrent) {
+	cmd := exec.Command("sh", "-c", flags.OnComplete)
+	cmd.Env = append(os.Environ(),
+		"TORRENT_NAME="+t.Name(),
+		"TORRENT_INFOHASH="+t.InfoHash().HexString(),
+		"TORRENT_DATA="+w.dataPath(t),
+	)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		log.Printf("error running completion hook for %q: %s", t.Name(), err)
+	}
+}
+
+// Seeds the completed torrent until the ratio or time limit is reached.
+// Returns false if there are no limits and it's to be seeded indefinitely,
+// or if it's dropped first.
+func seedUntilLimit(t *torrent.Torrent) bool {
+	if flags.SeedRatio == 0 && flags.SeedTime == 0 {
+		return !flags.Seed
+	}
+	var timeout <-chan time.Time
+	if flags.SeedTime != 0 {
+		timeout = time.After(flags.SeedTime)
+	}
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for {
+		if flags.SeedRatio != 0 && float64(t.BytesUploaded()) >= flags.SeedRatio*float64(t.Length()) {
+			return true
+		}
+		select {
+		case <-timeout:
+			return true
+		case <-t.Closed():
+			return false
+		case <-ticker.C:
+		}
+	}
+}
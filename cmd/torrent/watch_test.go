@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/internal/testutil"
+	"github.com/anacrolix/torrent/util/dirwatch"
+)
+
+// Returns a watcher with its directories in dir, and a client storing data
+// in dir/data.
+func testWatcher(t *testing.T, dir string) *watcher {
+	w := &watcher{
+		processing: filepath.Join(dir, "processing"),
+		done:       filepath.Join(dir, "done"),
+		dataDir:    filepath.Join(dir, "data"),
+		pending:    make(map[string]int),
+	}
+	for _, d := range []string{w.processing, w.done, w.dataDir} {
+		require.NoError(t, os.MkdirAll(d, 0750))
+	}
+	cl, err := torrent.NewClient(&torrent.Config{
+		ListenAddr:      "localhost:0",
+		NoDHT:           true,
+		DisableTrackers: true,
+		DataDir:         w.dataDir,
+	})
+	require.NoError(t, err)
+	w.client = cl
+	return w
+}
+
+func waitWatcher(t *testing.T, w *watcher) {
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("torrents weren't finished with")
+	}
+}
+
+func TestWatchCompletedTorrentMoved(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	w := testWatcher(t, dir)
+	defer w.client.Close()
+	moveTo := filepath.Join(dir, "moved")
+	require.NoError(t, os.Mkdir(moveTo, 0750))
+	defer func(old string) { flags.MoveTo = old }(flags.MoveTo)
+	flags.MoveTo = moveTo
+	testutil.CreateDummyTorrentData(w.dataDir)
+	file := filepath.Join(dir, "greeting.torrent")
+	f, err := os.Create(file)
+	require.NoError(t, err)
+	require.NoError(t, testutil.GreetingMetaInfo().Write(f))
+	f.Close()
+	w.add(dirwatch.Event{Change: dirwatch.Added, TorrentFilePath: file})
+	waitWatcher(t, w)
+	_, err = os.Stat(filepath.Join(w.done, "greeting.torrent"))
+	assert.NoError(t, err)
+	assert.Empty(t, w.pending)
+	assert.Empty(t, w.client.Torrents())
+	b, err := ioutil.ReadFile(filepath.Join(moveTo, testutil.GreetingFileName))
+	require.NoError(t, err)
+	assert.EqualValues(t, testutil.GreetingFileContents, b)
+}
+
+func TestWatchTorrentDroppedBeforeInfo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	w := testWatcher(t, dir)
+	defer w.client.Close()
+	ih := testutil.GreetingMetaInfo().Info.Hash()
+	uri := "magnet:?xt=urn:btih:" + ih.HexString()
+	file := filepath.Join(dir, "greeting.magnet")
+	require.NoError(t, ioutil.WriteFile(file, []byte(uri+"\n"), 0640))
+	w.add(dirwatch.Event{Change: dirwatch.Added, MagnetURI: uri, MagnetFilePath: file})
+	tt, ok := w.client.Torrent(ih)
+	require.True(t, ok)
+	tt.Drop()
+	waitWatcher(t, w)
+	// It's left in processing, to be added again next time.
+	_, err = os.Stat(filepath.Join(w.processing, "greeting.magnet"))
+	assert.NoError(t, err)
+}
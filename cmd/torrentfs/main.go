@@ -30,13 +30,13 @@ var (
 			log.Fatal(err)
 		}
 		return filepath.Join(_user.HomeDir, ".config/transmission/torrents")
-	}(), "torrent files in this location describe the contents of the mounted filesystem")
+	}(), "torrent files in this location describe the contents of the mounted filesystem. If empty, torrents are only added through the mount's .control directory")
 	downloadDir = flag.String("downloadDir", "", "location to save torrent data")
 	mountDir    = flag.String("mountDir", "", "location the torrent contents are made available")
 
 	disableTrackers = flag.Bool("disableTrackers", false, "disables trackers")
 	testPeer        = flag.String("testPeer", "", "the address for a test peer")
-	readaheadBytes  = flag.Int64("readaheadBytes", 10*1024*1024, "bytes to readahead in each torrent from the last read piece")
+	readaheadBytes  = flag.Int64("readaheadBytes", 10*1024*1024, "the most bytes to readahead in files being read sequentially")
 	listenAddr      = flag.String("listenAddr", ":6882", "incoming connection address")
 
 	testPeerAddr *net.TCPAddr
@@ -75,6 +75,37 @@ func addTestPeer(client *torrent.Client) {
 	}
 }
 
+func watchTorrentPath(client *torrent.Client) {
+	dw, err := dirwatch.New(*torrentPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go func() {
+		for ev := range dw.Events {
+			switch ev.Change {
+			case dirwatch.Added:
+				if ev.TorrentFilePath != "" {
+					_, err := client.AddTorrentFromFile(ev.TorrentFilePath)
+					if err != nil {
+						log.Printf("error adding torrent to client: %s", err)
+					}
+				} else if ev.MagnetURI != "" {
+					_, err := client.AddMagnet(ev.MagnetURI)
+					if err != nil {
+						log.Printf("error adding magnet: %s", err)
+					}
+				}
+			case dirwatch.Removed:
+				T, ok := client.Torrent(ev.InfoHash)
+				if !ok {
+					break
+				}
+				T.Drop()
+			}
+		}
+	}()
+}
+
 func main() {
 	flag.Parse()
 	if flag.NArg() != 0 {
@@ -106,36 +137,12 @@ func main() {
 	http.DefaultServeMux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
 		client.WriteStatus(w)
 	})
-	dw, err := dirwatch.New(*torrentPath)
-	if err != nil {
-		log.Fatal(err)
+	if *torrentPath != "" {
+		watchTorrentPath(client)
 	}
-	go func() {
-		for ev := range dw.Events {
-			switch ev.Change {
-			case dirwatch.Added:
-				if ev.TorrentFilePath != "" {
-					_, err := client.AddTorrentFromFile(ev.TorrentFilePath)
-					if err != nil {
-						log.Printf("error adding torrent to client: %s", err)
-					}
-				} else if ev.MagnetURI != "" {
-					_, err := client.AddMagnet(ev.MagnetURI)
-					if err != nil {
-						log.Printf("error adding magnet: %s", err)
-					}
-				}
-			case dirwatch.Removed:
-				T, ok := client.Torrent(ev.InfoHash)
-				if !ok {
-					break
-				}
-				T.Drop()
-			}
-		}
-	}()
 	resolveTestPeerAddr()
 	fs := torrentfs.New(client)
+	fs.MaxReadahead = *readaheadBytes
 	go exitSignalHandlers(fs)
 	go func() {
 		for {
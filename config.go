@@ -1,6 +1,8 @@
 package torrent
 
 import (
+	"time"
+
 	"github.com/anacrolix/torrent/dht"
 	"github.com/anacrolix/torrent/iplist"
 	"github.com/anacrolix/torrent/storage"
@@ -42,4 +44,45 @@ type Config struct {
 	DisableIPv6 bool `long:"disable-ipv6"`
 	// Perform logging and any other behaviour that will help debug.
 	Debug bool `help:"enable debug logging"`
+	// Maximum number of pieces hashed concurrently across all torrents. If
+	// zero, the number of CPUs is used.
+	HashWorkers int
+	// Maximum bytes per second read from storage for piece hashing. Zero
+	// means unlimited.
+	HashRateLimit int64
+	// Maximum peers per torrent that are uploaded to at once, including the
+	// optimistic unchoke. Defaults to 4.
+	UploadSlots int
+	// How upload slots are given out when seeding.
+	SeedChoking SeedChokingAlgorithm
+	// The number of pieces a peer must be shown to have corrupted before
+	// its IP is banned. Defaults to 2.
+	BanThreshold int
+	// End-game, where outstanding requests are duplicated to other peers,
+	// begins when no more than this many wanted chunks remain unrequested.
+	EndGameThreshold int
+	// The most peers a chunk is requested from at once in end-game.
+	// Defaults to 3.
+	EndGameMaxDuplicates int
+	// The request queue for each peer is sized to hold this much data
+	// beyond its round-trip time, at its measured download rate. Defaults
+	// to 3s.
+	RequestQueueTime time.Duration
+	// A peer that leaves a request unsatisfied for this long is snubbed,
+	// and its requests are given to other peers. Defaults to a minute.
+	RequestTimeout time.Duration
+	// How pieces of equal priority are ordered for requesting. Defaults to
+	// rarest first.
+	PieceSelection PieceSelectionAlgorithm
+	// Orders pieces for requesting, unless set per Torrent. Defaults to a
+	// ReaderPiecePicker using PieceSelection.
+	PiecePicker PiecePicker
+	// Limits on connections across all torrents. Can be changed with
+	// Client.SetConnLimits.
+	ConnLimits ConnLimits
+	// Limits on the torrents active at once. Can be changed with
+	// Client.SetQueueConfig.
+	Queue QueueConfig
+	// Called as peers connect and disconnect, and pieces and chunks move.
+	Callbacks Callbacks
 }
@@ -25,24 +25,40 @@ import (
 
 var optimizedCancels = expvar.NewInt("optimizedCancels")
 
-type peerSource byte
+// How a peer was discovered.
+type PeerSource byte
 
 const (
-	peerSourceTracker  = '\x00' // It's the default.
-	peerSourceIncoming = 'I'
-	peerSourceDHT      = 'H'
-	peerSourcePEX      = 'X'
+	PeerSourceTracker  PeerSource = '\x00' // It's the default.
+	PeerSourceIncoming PeerSource = 'I'
+	PeerSourceDHT      PeerSource = 'H'
+	PeerSourcePEX      PeerSource = 'X'
 )
 
+func (me PeerSource) String() string {
+	switch me {
+	case PeerSourceIncoming:
+		return "incoming"
+	case PeerSourceDHT:
+		return "dht"
+	case PeerSourcePEX:
+		return "pex"
+	default:
+		return "tracker"
+	}
+}
+
 // Maintains the state of a connection with a peer.
 type connection struct {
 	t         *Torrent
 	conn      net.Conn
 	rw        io.ReadWriter // The real slim shady
 	encrypted bool
-	Discovery peerSource
+	Discovery PeerSource
 	uTP       bool
 	closed    missinggo.Event
+	// Why we closed the connection, for Callbacks.PeerDisconnected.
+	closeReason error
 
 	UnwantedChunksReceived int
 	UsefulChunksReceived   int
@@ -54,11 +70,23 @@ type connection struct {
 	completedHandshake      time.Time
 	lastUsefulChunkReceived time.Time
 	lastChunkSent           time.Time
+	lastUnchoked            time.Time
+	// Chunk counts when the choker last ran, to determine recent rates.
+	rechokeChunksReceived int
+	rechokeChunksSent     int
+
+	// Payload transfer rates with the peer.
+	downloadRate rateEstimate
+	uploadRate   rateEstimate
+	// The shortest time a request has taken to be satisfied.
+	minRequestLatency time.Duration
+	// Set when the peer lets a request time out, until it sends a chunk.
+	snubbed bool
 
 	// Stuff controlled by the local peer.
 	Interested       bool
 	Choked           bool
-	Requests         map[request]struct{}
+	Requests         map[request]time.Time
 	requestsLowWater int
 	// Indexed by metadata piece, set to true if posted and pending a
 	// response.
@@ -82,6 +110,8 @@ type connection struct {
 	peerMinPieces int
 	// Pieces we've accepted chunks for from the peer.
 	peerTouchedPieces map[int]struct{}
+	// Pieces that are being redownloaded only from this peer.
+	redownloads bitmap.Bitmap
 
 	PeerMaxRequests  int // Maximum pending requests the peer allows.
 	PeerExtensionIDs map[string]byte
@@ -140,6 +170,7 @@ func (cn *connection) completedString() string {
 // messages.
 func (cn *connection) setNumPieces(num int) error {
 	cn.peerPieces.RemoveRange(num, -1)
+	cn.t.addConnPieceAvailability(cn, 1)
 	cn.peerPiecesChanged()
 	return nil
 }
@@ -187,6 +218,9 @@ func (cn *connection) statusFlags() (ret string) {
 	if cn.PeerChoked {
 		c('c')
 	}
+	if cn.snubbed {
+		c('S')
+	}
 	return
 }
 
@@ -289,9 +323,9 @@ func (cn *connection) requestedMetadataPiece(index int) bool {
 
 // The actual value to use as the maximum outbound requests.
 func (cn *connection) nominalMaxRequests() (ret int) {
-	ret = cn.PeerMaxRequests
-	if ret > 64 {
-		ret = 64
+	ret = cn.requestQueueDepth()
+	if ret > cn.PeerMaxRequests {
+		ret = cn.PeerMaxRequests
 	}
 	return
 }
@@ -312,9 +346,16 @@ func (cn *connection) Request(chunk request) bool {
 		return false
 	}
 	if cn.Requests == nil {
-		cn.Requests = make(map[request]struct{}, cn.PeerMaxRequests)
+		cn.Requests = make(map[request]time.Time, cn.PeerMaxRequests)
+	}
+	cn.Requests[chunk] = time.Now()
+	if cn.t.pendingRequests == nil {
+		cn.t.pendingRequests = make(map[request]int)
+	}
+	cn.t.pendingRequests[chunk]++
+	if cn.t.pendingRequests[chunk] == 1 {
+		cn.t.chunkRequestedChanged(chunk, true)
 	}
-	cn.Requests[chunk] = struct{}{}
 	cn.requestsLowWater = len(cn.Requests) / 2
 	cn.Post(pp.Message{
 		Type:   pp.Request,
@@ -325,12 +366,31 @@ func (cn *connection) Request(chunk request) bool {
 	return true
 }
 
-// Returns true if an unsatisfied request was canceled.
-func (cn *connection) Cancel(r request) bool {
+// Forgets an outstanding request, returning false if there wasn't one.
+func (cn *connection) deleteRequest(r request) bool {
 	if !cn.RequestPending(r) {
 		return false
 	}
 	delete(cn.Requests, r)
+	cn.t.pendingRequests[r]--
+	if cn.t.pendingRequests[r] == 0 {
+		delete(cn.t.pendingRequests, r)
+		cn.t.chunkRequestedChanged(r, false)
+	}
+	return true
+}
+
+func (cn *connection) deleteAllRequests() {
+	for r := range cn.Requests {
+		cn.deleteRequest(r)
+	}
+}
+
+// Returns true if an unsatisfied request was canceled.
+func (cn *connection) Cancel(r request) bool {
+	if !cn.deleteRequest(r) {
+		return false
+	}
 	cn.Post(pp.Message{
 		Type:   pp.Cancel,
 		Index:  r.Index,
@@ -371,6 +431,7 @@ func (cn *connection) Unchoke() {
 		Type: pp.Unchoke,
 	})
 	cn.Choked = false
+	cn.lastUnchoked = time.Now()
 }
 
 func (cn *connection) SetInterested(interested bool) {
@@ -398,10 +459,11 @@ var (
 
 // Writes buffers to the socket from the write channel.
 func (cn *connection) writer(keepAliveTimeout time.Duration) {
+	var err error
 	defer func() {
 		cn.mu().Lock()
 		defer cn.mu().Unlock()
-		cn.Close()
+		cn.closeWithReason(err)
 	}()
 	// Reduce write syscalls.
 	buf := bufio.NewWriter(cn.rw)
@@ -411,12 +473,13 @@ func (cn *connection) writer(keepAliveTimeout time.Duration) {
 		for cn.outgoingUnbufferedMessages.Len() != 0 {
 			msg := cn.outgoingUnbufferedMessages.Remove(cn.outgoingUnbufferedMessages.Front()).(pp.Message)
 			cn.mu().Unlock()
-			b, err := msg.MarshalBinary()
-			if err != nil {
-				panic(err)
+			b, merr := msg.MarshalBinary()
+			if merr != nil {
+				panic(merr)
 			}
 			connectionWriterWrite.Add(1)
-			n, err := buf.Write(b)
+			var n int
+			n, err = buf.Write(b)
 			if err != nil {
 				return
 			}
@@ -430,7 +493,7 @@ func (cn *connection) writer(keepAliveTimeout time.Duration) {
 		cn.mu().Unlock()
 		connectionWriterFlush.Add(1)
 		if buf.Buffered() != 0 {
-			if buf.Flush() != nil {
+			if err = buf.Flush(); err != nil {
 				return
 			}
 			keepAliveTimer.Reset(keepAliveTimeout)
@@ -505,6 +568,16 @@ func (cn *connection) fillRequests() {
 		}
 		return cn.requestPiecePendingChunks(piece)
 	})
+	if cn.PeerChoked || len(cn.Requests) >= cn.nominalMaxRequests() {
+		return
+	}
+	if cn.t.endGame() {
+		cn.pieceRequestOrder.IterTyped(func(piece int) bool {
+			return cn.t.connRequestPieceDuplicateChunks(cn, piece)
+		})
+		return
+	}
+	cn.requestDeadlinesAtRisk()
 }
 
 func (cn *connection) requestPiecePendingChunks(piece int) (again bool) {
@@ -516,27 +589,25 @@ func (cn *connection) stopRequestingPiece(piece int) {
 }
 
 func (cn *connection) updatePiecePriority(piece int) {
+	if cn.setPieceOrder(piece) {
+		cn.updateRequests()
+	}
+}
+
+// Puts the piece in its place in the request order, without updating
+// requests. Returns true if the piece is to be requested.
+func (cn *connection) setPieceOrder(piece int) bool {
 	tpp := cn.t.piecePriority(piece)
-	if !cn.PeerHasPiece(piece) {
+	if !cn.PeerHasPiece(piece) || !cn.t.pieces[piece].mayRequestFrom(cn) {
 		tpp = PiecePriorityNone
 	}
 	if tpp == PiecePriorityNone {
 		cn.stopRequestingPiece(piece)
-		return
-	}
-	prio := cn.getPieceInclination()[piece]
-	switch tpp {
-	case PiecePriorityNormal:
-	case PiecePriorityReadahead:
-		prio -= cn.t.numPieces()
-	case PiecePriorityNext, PiecePriorityNow:
-		prio -= 2 * cn.t.numPieces()
-	default:
-		panic(tpp)
+		return false
 	}
-	prio += piece / 2
+	prio := cn.t.piecePicker().PieceOrder(cn.pickerPiece(piece, tpp))
 	cn.pieceRequestOrder.Set(piece, prio)
-	cn.updateRequests()
+	return true
 }
 
 func (cn *connection) getPieceInclination() []int {
@@ -581,12 +652,15 @@ func (cn *connection) peerSentHave(piece int) error {
 	}
 	cn.raisePeerMinPieces(piece + 1)
 	cn.peerPieces.Set(piece, true)
+	if cn.t.haveInfo() {
+		cn.t.pieceAvailabilityChanged(piece, 1)
+		cn.t.updateAvailabilityOrder(cn)
+	}
 	cn.peerHasPieceChanged(piece)
 	return nil
 }
 
 func (cn *connection) peerSentBitfield(bf []bool) error {
-	cn.peerHasAll = false
 	if len(bf)%8 != 0 {
 		panic("expected bitfield length divisible by 8")
 	}
@@ -597,27 +671,31 @@ func (cn *connection) peerSentBitfield(bf []bool) error {
 		// Ignore known excess pieces.
 		bf = bf[:cn.t.numPieces()]
 	}
-	for i, have := range bf {
-		if have {
-			cn.raisePeerMinPieces(i + 1)
+	cn.changePeerPieces(func() {
+		cn.peerHasAll = false
+		for i, have := range bf {
+			if have {
+				cn.raisePeerMinPieces(i + 1)
+			}
+			cn.peerPieces.Set(i, have)
 		}
-		cn.peerPieces.Set(i, have)
-	}
-	cn.peerPiecesChanged()
+	})
 	return nil
 }
 
 func (cn *connection) peerSentHaveAll() error {
-	cn.peerHasAll = true
-	cn.peerPieces.Clear()
-	cn.peerPiecesChanged()
+	cn.changePeerPieces(func() {
+		cn.peerHasAll = true
+		cn.peerPieces.Clear()
+	})
 	return nil
 }
 
 func (cn *connection) peerSentHaveNone() error {
-	cn.peerPieces.Clear()
-	cn.peerHasAll = false
-	cn.peerPiecesChanged()
+	cn.changePeerPieces(func() {
+		cn.peerPieces.Clear()
+		cn.peerHasAll = false
+	})
 	return nil
 }
 
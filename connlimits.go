@@ -0,0 +1,207 @@
+package torrent
+
+import (
+	"sort"
+	"time"
+)
+
+// Limits on peer connections across all of a Client's torrents, on top of
+// the limits for each torrent. Zero values mean no limit. See
+// Client.SetConnLimits.
+type ConnLimits struct {
+	// Established connections. When reached, a torrent with fewer than its
+	// share of them may replace the worst connection of a torrent with
+	// more.
+	MaxConns int
+	// Outgoing connections still being dialed or handshaked.
+	MaxHalfOpen int
+	// Incoming connections accepted and not yet handshaked. No more are
+	// accepted until some complete.
+	MaxPendingAccepts int
+}
+
+func (cl *Client) ConnLimits() ConnLimits {
+	cl.mu.RLock()
+	defer cl.mu.RUnlock()
+	return cl.config.ConnLimits
+}
+
+// Changes the connection limits. Connections beyond a lowered MaxConns are
+// dropped, worst first, from the torrents with the most of them.
+func (cl *Client) SetConnLimits(l ConnLimits) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	cl.config.ConnLimits = l
+	for l.MaxConns > 0 && cl.numConns > l.MaxConns {
+		c := cl.worstOverShareConn(0)
+		if c == nil {
+			break
+		}
+		c.closeWithReason(ErrConnLimited)
+		c.t.deleteConnection(c)
+	}
+	cl.openNewConnsFairly()
+	cl.event.Broadcast()
+}
+
+func (cl *Client) connsFull() bool {
+	max := cl.config.ConnLimits.MaxConns
+	return max > 0 && cl.numConns >= max
+}
+
+func (cl *Client) halfOpenFull() bool {
+	max := cl.config.ConnLimits.MaxHalfOpen
+	return max > 0 && cl.numHalfOpen >= max
+}
+
+func (cl *Client) pendingAcceptsFull() bool {
+	max := cl.config.ConnLimits.MaxPendingAccepts
+	return max > 0 && cl.pendingAccepts >= max
+}
+
+// The number of connections each torrent that wants them is entitled to
+// under MaxConns.
+func (cl *Client) fairConnShare() int {
+	n := cl.numWantingConns
+	if n == 0 {
+		n = 1
+	}
+	ret := cl.config.ConnLimits.MaxConns / n
+	if ret < 1 {
+		ret = 1
+	}
+	return ret
+}
+
+func (t *Torrent) wantsConns() bool {
+	return !t.stopped() && (t.seeding() || t.needData())
+}
+
+// Updates whether t is counted among the torrents that want connections.
+func (t *Torrent) updateWantsConns() {
+	want := !t.closed.IsSet() && t.wantsConns()
+	if want == t.wantingConns {
+		return
+	}
+	t.wantingConns = want
+	if want {
+		t.cl.numWantingConns++
+	} else {
+		t.cl.numWantingConns--
+	}
+}
+
+func (t *Torrent) addConn(c *connection) {
+	t.conns = append(t.conns, c)
+	if !t.closed.IsSet() {
+		t.cl.numConns++
+	}
+}
+
+func (t *Torrent) addHalfOpen(addr string) {
+	t.halfOpen[addr] = struct{}{}
+	if !t.closed.IsSet() {
+		t.cl.numHalfOpen++
+	}
+}
+
+func (t *Torrent) deleteHalfOpen(addr string) {
+	if _, ok := t.halfOpen[addr]; !ok {
+		panic("invariant broken")
+	}
+	delete(t.halfOpen, addr)
+	if !t.closed.IsSet() {
+		t.cl.numHalfOpen--
+	}
+}
+
+// Stops counting a closed torrent's connections and dials toward the
+// Client's. Those that finish later aren't counted either.
+func (cl *Client) forgetConns(t *Torrent) {
+	cl.numConns -= len(t.conns)
+	cl.numHalfOpen -= len(t.halfOpen)
+	t.updateWantsConns()
+}
+
+// Returns the worst connection, of those established for at least minAge,
+// held by torrents with more than their share. Torrents that don't want
+// connections have no share.
+func (cl *Client) worstOverShareConn(minAge time.Duration) *connection {
+	share := cl.fairConnShare()
+	var (
+		worst    *connection
+		worstKey worstConnsSortKey
+	)
+	for _, t := range cl.torrents {
+		if t.wantingConns && len(t.conns) <= share {
+			continue
+		}
+		for _, c := range t.conns {
+			if c.closed.IsSet() || time.Since(c.completedHandshake) < minAge {
+				continue
+			}
+			key := cl.worstConnsSortKey(c)
+			if worst == nil || key.Less(worstKey) {
+				worst, worstKey = c, key
+			}
+		}
+	}
+	return worst
+}
+
+// Returns a connection to drop to make room for a new one for t when the
+// Client is at MaxConns, or nil if t isn't entitled to one. A torrent at its
+// share may only replace its own bad connections.
+func (cl *Client) connToEvict(t *Torrent) *connection {
+	if len(t.conns) >= cl.fairConnShare() {
+		return t.worstBadConn(cl)
+	}
+	// Give connections 1 minute to prove themselves.
+	return cl.worstOverShareConn(time.Minute)
+}
+
+// Sorts torrents by how many connections they have and are dialing, fewest
+// first.
+type torrentsByConns []*Torrent
+
+func (me torrentsByConns) Len() int      { return len(me) }
+func (me torrentsByConns) Swap(i, j int) { me[i], me[j] = me[j], me[i] }
+
+func (me torrentsByConns) Less(i, j int) bool {
+	return len(me[i].conns)+len(me[i].halfOpen) < len(me[j].conns)+len(me[j].halfOpen)
+}
+
+// Dials peers for all torrents, a connection at a time in turn, starting
+// with those that have the fewest connections, so that no torrent takes the
+// Client-wide limits for itself.
+func (cl *Client) openNewConnsFairly() {
+	var ts torrentsByConns
+	for _, t := range cl.torrents {
+		ts = append(ts, t)
+	}
+	sort.Sort(ts)
+	for {
+		opened := false
+		for _, t := range ts {
+			if cl.openNewConn(t) {
+				opened = true
+			}
+		}
+		if !opened {
+			break
+		}
+	}
+	for _, t := range ts {
+		t.updateWantPeersEvent()
+	}
+}
+
+// Opens new connections after t has lost one or finished dialing. If the
+// slot it freed counts toward a Client-wide limit, the torrents share it.
+func (cl *Client) reopenConns(t *Torrent) {
+	if cl.config.ConnLimits.MaxConns > 0 || cl.config.ConnLimits.MaxHalfOpen > 0 {
+		cl.openNewConnsFairly()
+		return
+	}
+	cl.openNewConns(t)
+}
@@ -0,0 +1,91 @@
+package torrent
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Adds n connections to the torrent that are old enough to be evicted.
+func addOldConns(tt *Torrent, n int) {
+	for i := 0; i < n; i++ {
+		c := testAddConn(tt)
+		c.PeerID[0] = byte(i)
+		c.completedHandshake = time.Now().Add(-2 * time.Minute)
+	}
+}
+
+func TestConnLimitsEvictFromOtherTorrents(t *testing.T) {
+	cfg := TestingConfig
+	cfg.ConnLimits.MaxConns = 4
+	cl, err := NewClient(&cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt1 := testTorrentPieces(t, cl, 1)
+	tt2 := testTorrentPieces(t, cl, 2)
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	addOldConns(tt1, 4)
+	assert.Equal(t, 2, cl.fairConnShare())
+	assert.True(t, cl.wantConns(tt2))
+	assert.True(t, cl.addConnection(tt2, &connection{}))
+	assert.Len(t, tt1.conns, 3)
+	assert.Len(t, tt2.conns, 1)
+	// tt1 is over its share, and its connections aren't bad.
+	assert.False(t, cl.wantConns(tt1))
+	assert.True(t, cl.wantConns(tt2))
+}
+
+func TestSetConnLimits(t *testing.T) {
+	cl, err := NewClient(&TestingConfig)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt1 := testTorrentPieces(t, cl, 1)
+	tt2 := testTorrentPieces(t, cl, 2)
+	cl.mu.Lock()
+	addOldConns(tt1, 3)
+	addOldConns(tt2, 1)
+	tt2.addHalfOpen("1.2.3.4:5")
+	cl.mu.Unlock()
+	cl.SetConnLimits(ConnLimits{MaxConns: 2})
+	cl.mu.Lock()
+	assert.Len(t, tt1.conns, 1)
+	assert.Len(t, tt2.conns, 1)
+	cl.mu.Unlock()
+	cl.SetConnLimits(ConnLimits{MaxHalfOpen: 1})
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	tt1.peers[peersKey{"5.6.7.8", 9}] = Peer{}
+	assert.True(t, cl.wantConns(tt1))
+	// tt2's dial takes the only half-open slot.
+	assert.False(t, cl.openNewConn(tt1))
+	assert.Len(t, tt1.peers, 1)
+}
+
+func TestConnLimitsCountsDroppedTorrent(t *testing.T) {
+	cl, err := NewClient(&TestingConfig)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt1 := testTorrentPieces(t, cl, 1)
+	tt2 := testTorrentPieces(t, cl, 2)
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	addOldConns(tt1, 2)
+	addOldConns(tt2, 1)
+	tt1.addHalfOpen("1.2.3.4:5")
+	assert.Equal(t, 3, cl.numConns)
+	assert.Equal(t, 1, cl.numHalfOpen)
+	assert.Equal(t, 2, cl.numWantingConns)
+	c := tt1.conns[0]
+	require.NoError(t, cl.dropTorrent(tt1.infoHash))
+	assert.Equal(t, 1, cl.numConns)
+	assert.Equal(t, 0, cl.numHalfOpen)
+	assert.Equal(t, 1, cl.numWantingConns)
+	// The dropped torrent's connections and dials finish afterwards.
+	tt1.deleteConnection(c)
+	tt1.deleteHalfOpen("1.2.3.4:5")
+	assert.Equal(t, 1, cl.numConns)
+	assert.Equal(t, 0, cl.numHalfOpen)
+}
@@ -0,0 +1,114 @@
+package torrent
+
+import (
+	"github.com/anacrolix/missinggo/itertools"
+
+	pp "github.com/anacrolix/torrent/peer_protocol"
+)
+
+// Normally a chunk is only requested from one peer at a time. Near the end of
+// a download that leaves us waiting on whichever peers are slowest, so once
+// there's nothing left that hasn't been requested, outstanding requests are
+// duplicated to other unchoked peers that have the piece. When a chunk
+// arrives, the requests for it to the other peers are cancelled, and any
+// copies that arrive anyway are counted as wasted.
+
+const defaultEndGameMaxDuplicates = 3
+
+func (cl *Client) endGameMaxDuplicates() int {
+	if cl.config.EndGameMaxDuplicates > 0 {
+		return cl.config.EndGameMaxDuplicates
+	}
+	return defaultEndGameMaxDuplicates
+}
+
+// Returns true if no more than Config.EndGameThreshold wanted chunks have
+// yet to be requested from any peer.
+func (t *Torrent) endGame() bool {
+	return t.haveInfo() && t.numUnrequested <= t.cl.config.EndGameThreshold
+}
+
+// The chunks of wanted pieces that aren't dirty and haven't been requested
+// are counted as they change, so endGame needn't look at every piece. Each
+// piece keeps its own count, which is added to Torrent.numUnrequested while
+// the piece is wanted. syncUnrequested must be called whenever a piece's
+// priority or hashing state changes.
+
+// Returns true if the piece's unrequested chunks count toward end-game. It
+// follows wantPieceIndex, by way of the cached priority.
+func (t *Torrent) pieceCountsUnrequested(piece int) bool {
+	p := &t.pieces[piece]
+	return p.priority != PiecePriorityNone && !p.QueuedForHash && !p.Hashing
+}
+
+// Brings Torrent.numUnrequested up to date with the piece.
+func (t *Torrent) syncUnrequested(piece int) {
+	p := &t.pieces[piece]
+	n := 0
+	if t.pieceCountsUnrequested(piece) {
+		n = p.numUnrequested
+	}
+	t.numUnrequested += n - p.countedUnrequested
+	p.countedUnrequested = n
+}
+
+func (t *Torrent) addUnrequested(piece int, delta int) {
+	t.pieces[piece].numUnrequested += delta
+	t.syncUnrequested(piece)
+}
+
+// Recounts the piece's unrequested chunks, after its dirty chunks are reset.
+func (t *Torrent) recountUnrequested(piece int) {
+	p := &t.pieces[piece]
+	p.numUnrequested = 0
+	p.undirtiedChunkIndices().IterTyped(func(ci int) bool {
+		if !t.chunkIndexRequested(piece, ci) {
+			p.numUnrequested++
+		}
+		return true
+	})
+	t.syncUnrequested(piece)
+}
+
+func (t *Torrent) chunkIndexRequested(piece, chunk int) bool {
+	return t.pendingRequests[request{pp.Integer(piece), t.chunkIndexSpec(chunk, piece)}] != 0
+}
+
+// Called when a request for the chunk is first made, or the last one
+// withdrawn.
+func (t *Torrent) chunkRequestedChanged(r request, requested bool) {
+	if !t.haveInfo() {
+		return
+	}
+	piece := int(r.Index)
+	if t.pieces[piece].DirtyChunks.Contains(chunkIndex(r.chunkSpec, t.chunkSize)) {
+		return
+	}
+	if requested {
+		t.addUnrequested(piece, -1)
+	} else {
+		t.addUnrequested(piece, 1)
+	}
+}
+
+// Duplicates requests for the piece's pending chunks that are outstanding
+// with other peers.
+func (t *Torrent) connRequestPieceDuplicateChunks(c *connection, piece int) (more bool) {
+	if !c.PeerHasPiece(piece) {
+		return true
+	}
+	max := t.cl.endGameMaxDuplicates()
+	chunkIndices := t.pieces[piece].undirtiedChunkIndices().ToSortedSlice()
+	return itertools.ForPerm(len(chunkIndices), func(i int) bool {
+		req := request{pp.Integer(piece), t.chunkIndexSpec(chunkIndices[i], piece)}
+		if c.RequestPending(req) || t.pendingRequests[req] >= max {
+			return true
+		}
+		dup := t.pendingRequests[req] != 0
+		more := c.Request(req)
+		if dup && c.RequestPending(req) {
+			endGameRequests.Add(1)
+		}
+		return more
+	})
+}
@@ -0,0 +1,120 @@
+package torrent
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// Adds a torrent with a single incomplete piece of two chunks, and waits for
+// its initial check.
+func endGameTestTorrent(t *testing.T, cl *Client) *Torrent {
+	ie := metainfo.InfoEx{
+		Info: metainfo.Info{
+			PieceLength: 2 * defaultChunkSize,
+			// Doesn't match the empty data in storage.
+			Pieces: bytes.Repeat([]byte{1}, 20),
+			Length: 2 * defaultChunkSize,
+		},
+	}
+	ie.UpdateBytes()
+	tt, _, err := cl.AddTorrentSpec(&TorrentSpec{
+		Info:     &ie,
+		InfoHash: ie.Hash(),
+	})
+	require.NoError(t, err)
+	tt.DownloadAll()
+	cl.mu.Lock()
+	for tt.pieces[0].QueuedForHash || tt.pieces[0].Hashing {
+		cl.event.Wait()
+	}
+	cl.mu.Unlock()
+	return tt
+}
+
+// Counts the wanted chunks that haven't been requested, the slow way, to
+// check Torrent.numUnrequested.
+func countUnrequested(t *Torrent) (n int) {
+	for i := range t.pieces {
+		if !t.wantPieceIndex(i) {
+			continue
+		}
+		t.pieces[i].undirtiedChunkIndices().IterTyped(func(ci int) bool {
+			if !t.chunkIndexRequested(i, ci) {
+				n++
+			}
+			return true
+		})
+	}
+	return
+}
+
+func TestEndGameDuplicatesRequests(t *testing.T) {
+	cfg := TestingConfig
+	cfg.EndGameMaxDuplicates = 2
+	cl, err := NewClient(&cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt := endGameTestTorrent(t, cl)
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	newConn := func() *connection {
+		c := &connection{
+			t:               tt,
+			PeerMaxRequests: 250,
+		}
+		c.peerPieces.Set(0, true)
+		return c
+	}
+	c1, c2, c3 := newConn(), newConn(), newConn()
+	assert.False(t, tt.endGame())
+	c1.updatePiecePriority(0)
+	assert.Len(t, c1.Requests, 2)
+	assert.True(t, tt.endGame())
+	c2.updatePiecePriority(0)
+	assert.Len(t, c2.Requests, 2)
+	// Both chunks are already requested from the maximum number of peers.
+	c3.updatePiecePriority(0)
+	assert.Len(t, c3.Requests, 0)
+	r := newRequest(0, 0, defaultChunkSize)
+	assert.EqualValues(t, 2, tt.pendingRequests[r])
+	assert.True(t, c1.Cancel(r))
+	assert.EqualValues(t, 1, tt.pendingRequests[r])
+	c3.fillRequests()
+	assert.Len(t, c3.Requests, 1)
+	assert.True(t, c3.RequestPending(r))
+	assert.Equal(t, countUnrequested(tt), tt.numUnrequested)
+}
+
+func TestEndGameThreshold(t *testing.T) {
+	cfg := TestingConfig
+	cfg.EndGameThreshold = 1
+	cl, err := NewClient(&cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt := endGameTestTorrent(t, cl)
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	assert.False(t, tt.endGame())
+	c := &connection{
+		t:               tt,
+		PeerMaxRequests: 250,
+	}
+	c.peerPieces.Set(0, true)
+	c.Request(newRequest(0, 0, defaultChunkSize))
+	assert.True(t, tt.endGame())
+	assert.Equal(t, 1, tt.numUnrequested)
+	// The chunk arriving from elsewhere doesn't change anything.
+	tt.pieces[0].unpendChunkIndex(0)
+	assert.Equal(t, 1, tt.numUnrequested)
+	// The other chunk is dirtied, then pended again.
+	tt.pieces[0].unpendChunkIndex(1)
+	assert.Equal(t, 0, tt.numUnrequested)
+	tt.pendAllChunkSpecs(0)
+	assert.Equal(t, countUnrequested(tt), tt.numUnrequested)
+	assert.Equal(t, 1, tt.numUnrequested)
+}
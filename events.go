@@ -0,0 +1,199 @@
+package torrent
+
+import (
+	"sort"
+
+	"github.com/anacrolix/missinggo/pubsub"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// What a torrent is doing. See Torrent.State.
+type TorrentState int
+
+const (
+	TorrentGettingInfo TorrentState = iota
+	// Pieces are being checked against the data in storage, after the info
+	// is received, or by VerifyData.
+	TorrentChecking
+	TorrentDownloading
+	// Downloading, but without progress for QueueConfig.StallTimeout.
+	TorrentStalled
+	TorrentSeeding
+	// All the wanted data is complete, and the torrent isn't seeding.
+	TorrentComplete
+	// Waiting in the Client's queue.
+	TorrentQueued
+	// Stopped by the Client's queue on reaching the seeding limits.
+	TorrentFinished
+	// Stopped by Pause.
+	TorrentStopped
+	// Storage has failed. See Torrent.StorageErr.
+	TorrentError
+)
+
+var torrentStateStrings = map[TorrentState]string{
+	TorrentGettingInfo: "getting info",
+	TorrentChecking:    "checking",
+	TorrentDownloading: "downloading",
+	TorrentStalled:     "stalled",
+	TorrentSeeding:     "seeding",
+	TorrentComplete:    "complete",
+	TorrentQueued:      "queued",
+	TorrentFinished:    "finished",
+	TorrentStopped:     "stopped",
+	TorrentError:       "error",
+}
+
+func (me TorrentState) String() string {
+	return torrentStateStrings[me]
+}
+
+type TorrentEventType int
+
+const (
+	// The torrent was added to the Client. Only given by
+	// Client.SubscribeEvents.
+	TorrentAdded TorrentEventType = iota
+	// The info was received. Info is set.
+	TorrentGotInfo
+	// State is set to the new state.
+	TorrentStateChanged
+	// All of a file's pieces have completed. File is set.
+	TorrentFileCompleted
+	// All of the torrent's pieces have completed. See Torrent.Complete.
+	TorrentCompleted
+	// The torrent was dropped. No events follow it.
+	TorrentDropped
+)
+
+var torrentEventTypeStrings = map[TorrentEventType]string{
+	TorrentAdded:         "added",
+	TorrentGotInfo:       "got info",
+	TorrentStateChanged:  "state changed",
+	TorrentFileCompleted: "file completed",
+	TorrentCompleted:     "completed",
+	TorrentDropped:       "dropped",
+}
+
+func (me TorrentEventType) String() string {
+	return torrentEventTypeStrings[me]
+}
+
+// A change to a torrent, from Torrent.SubscribeEvents or
+// Client.SubscribeEvents.
+type TorrentEvent struct {
+	Type    TorrentEventType
+	Torrent *Torrent
+	Info    *metainfo.InfoEx
+	State   TorrentState
+	File    *File
+}
+
+// The subscription emits TorrentEvents for the torrent, and ends when the
+// torrent is dropped.
+func (t *Torrent) SubscribeEvents() *pubsub.Subscription {
+	return t.events.Subscribe()
+}
+
+// The subscription emits TorrentEvents for all the Client's torrents,
+// including those added later, and ends when the Client is closed.
+func (cl *Client) SubscribeEvents() *pubsub.Subscription {
+	return cl.torrentEvents.Subscribe()
+}
+
+// Returns a channel that is closed when all the torrent's pieces are
+// complete. A new channel is returned if a piece is later found to be
+// incomplete.
+func (t *Torrent) Complete() <-chan struct{} {
+	t.cl.mu.Lock()
+	defer t.cl.mu.Unlock()
+	return t.completed.C()
+}
+
+func (t *Torrent) State() TorrentState {
+	t.cl.mu.RLock()
+	defer t.cl.mu.RUnlock()
+	return t.state()
+}
+
+// Returns the last error from the torrent's storage, until data is written
+// to it, or a piece is completed in it, successfully.
+func (t *Torrent) StorageErr() error {
+	t.cl.mu.RLock()
+	defer t.cl.mu.RUnlock()
+	return t.storageErr
+}
+
+// Records the outcome of a storage operation. nil clears an earlier error.
+func (t *Torrent) setStorageErr(err error) {
+	if err == t.storageErr {
+		return
+	}
+	t.storageErr = err
+	t.updateState()
+}
+
+func (t *Torrent) publishEvent(ev TorrentEvent) {
+	ev.Torrent = t
+	t.events.Publish(ev)
+	t.cl.torrentEvents.Publish(ev)
+}
+
+// Publishes the torrent's state if it has changed, and whether it wants
+// connections. Called wherever it might have.
+func (t *Torrent) updateState() {
+	t.updateWantsConns()
+	if t.closed.IsSet() {
+		return
+	}
+	s := t.state()
+	if s == t.publishedState {
+		return
+	}
+	t.publishedState = s
+	t.publishEvent(TorrentEvent{Type: TorrentStateChanged, State: s})
+}
+
+// Queues the piece to be checked against storage, as distinct from a check
+// of data just downloaded.
+func (t *Torrent) queueCheck(piece int) {
+	p := &t.pieces[piece]
+	if !p.checking {
+		p.checking = true
+		t.numCheckingPieces++
+	}
+	t.cl.queuePieceCheck(t, piece)
+}
+
+// Tracks the completion of the piece's files, and the torrent, after the
+// piece's completion changes.
+func (t *Torrent) updateFileCompletion(piece int) {
+	complete := t.pieceComplete(piece)
+	begin := int64(piece) * t.info.PieceLength
+	end := begin + t.info.PieceLength
+	// The first file ending after the piece begins.
+	i := sort.Search(len(t.files), func(i int) bool {
+		f := &t.files[i]
+		return f.offset+f.length > begin
+	})
+	for ; i < len(t.files) && t.files[i].offset < end; i++ {
+		if t.files[i].length == 0 {
+			continue
+		}
+		if !complete {
+			t.filePiecesLeft[i]++
+			continue
+		}
+		t.filePiecesLeft[i]--
+		if t.filePiecesLeft[i] == 0 {
+			f := t.files[i]
+			t.publishEvent(TorrentEvent{Type: TorrentFileCompleted, File: &f})
+		}
+	}
+	if !t.haveAllPieces() {
+		t.completed.Clear()
+	} else if t.completed.Set() {
+		t.publishEvent(TorrentEvent{Type: TorrentCompleted})
+	}
+}
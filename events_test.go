@@ -0,0 +1,101 @@
+package torrent
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/anacrolix/missinggo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/torrent/internal/testutil"
+)
+
+func TestTorrentEvents(t *testing.T) {
+	greetingTempDir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(greetingTempDir)
+	cfg := TestingConfig
+	cfg.Seed = true
+	cfg.DataDir = greetingTempDir
+	seeder, err := NewClient(&cfg)
+	require.NoError(t, err)
+	defer seeder.Close()
+	seeder.AddTorrent(mi)
+	cfg.Seed = false
+	cfg.DataDir, err = ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(cfg.DataDir)
+	leecher, err := NewClient(&cfg)
+	require.NoError(t, err)
+	defer leecher.Close()
+	sub := leecher.SubscribeEvents()
+	defer sub.Close()
+	lt, err := leecher.AddTorrent(mi)
+	require.NoError(t, err)
+	tsub := lt.SubscribeEvents()
+	lt.DownloadAll()
+	lt.AddPeers([]Peer{{
+		IP:   missinggo.AddrIP(seeder.ListenAddr()),
+		Port: missinggo.AddrPort(seeder.ListenAddr()),
+	}})
+	var (
+		types  []TorrentEventType
+		states []TorrentState
+	)
+	for v := range sub.Values {
+		ev := v.(TorrentEvent)
+		assert.Equal(t, lt, ev.Torrent)
+		types = append(types, ev.Type)
+		switch ev.Type {
+		case TorrentGotInfo:
+			assert.Equal(t, "greeting", ev.Info.Name)
+		case TorrentStateChanged:
+			states = append(states, ev.State)
+		case TorrentFileCompleted:
+			assert.Equal(t, "greeting", ev.File.Path())
+		}
+		if ev.Type == TorrentCompleted {
+			break
+		}
+	}
+	assert.Equal(t, []TorrentEventType{TorrentAdded, TorrentGotInfo}, types[:2])
+	assert.Contains(t, types, TorrentFileCompleted)
+	assert.Contains(t, states, TorrentChecking)
+	assert.Contains(t, states, TorrentDownloading)
+	assert.Equal(t, TorrentComplete, states[len(states)-1])
+	assert.Equal(t, TorrentComplete, lt.State())
+	select {
+	case <-lt.Complete():
+	default:
+		t.Fatal("not complete")
+	}
+	lt.Drop()
+	var last TorrentEvent
+	for v := range tsub.Values {
+		last = v.(TorrentEvent)
+	}
+	assert.Equal(t, TorrentDropped, last.Type)
+}
+
+func TestStorageErrClearedByRecheck(t *testing.T) {
+	greetingTempDir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(greetingTempDir)
+	cfg := TestingConfig
+	cfg.DataDir = greetingTempDir
+	cl, err := NewClient(&cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, err := cl.AddTorrent(mi)
+	require.NoError(t, err)
+	tt.VerifyData()
+	cl.mu.Lock()
+	tt.setStorageErr(errors.New("disk full"))
+	cl.mu.Unlock()
+	assert.Equal(t, TorrentError, tt.State())
+	// The data is all there, so checking it again is enough to recover.
+	tt.VerifyData()
+	assert.NoError(t, tt.StorageErr())
+	assert.Equal(t, TorrentComplete, tt.State())
+}
@@ -96,6 +96,31 @@ func (f *File) exclusivePieces() (begin, end int) {
 	return byteRegionExclusivePieces(f.offset, f.length, int64(f.t.usualPieceSize()))
 }
 
+// Returns a Reader bound to the file's data. Positions are relative to the
+// start of the file, reads end at the end of it, and only pieces with data
+// in the file are prioritized for reading.
+func (f *File) NewReader() *Reader {
+	return f.t.newReader(f.offset, f.length)
+}
+
 func (f *File) Cancel() {
 	f.t.CancelPieces(f.exclusivePieces())
 }
+
+// Sets the download priority of the pieces containing the file's data. This
+// is kept by storage that supports it. Pieces shared with other files are
+// only raised, so that skipping or lowering a file doesn't hold up its
+// neighbours.
+func (f *File) SetPriority(prio DownloadPriority) {
+	f.t.cl.mu.Lock()
+	defer f.t.cl.mu.Unlock()
+	begin, end := f.t.byteRegionPieces(f.offset, f.length)
+	xBegin, xEnd := f.exclusivePieces()
+	var pieces []int
+	for i := begin; i < end; i++ {
+		if i >= xBegin && i < xEnd || f.t.raisesPieceDownloadPriority(i, prio) {
+			pieces = append(pieces, i)
+		}
+	}
+	f.t.setPieceDownloadPriorities(pieces, prio)
+}
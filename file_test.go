@@ -1,9 +1,17 @@
 package torrent
 
 import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/torrent/metainfo"
 )
 
 func TestFileExclusivePieces(t *testing.T) {
@@ -20,3 +28,54 @@ func TestFileExclusivePieces(t *testing.T) {
 		assert.EqualValues(t, _case.end, end)
 	}
 }
+
+func TestFileNewReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	contents := map[string]string{"a": "hello", "b": "world", "c": "!!"}
+	mi := &metainfo.MetaInfo{}
+	mi.Info.Name = "multi"
+	mi.Info.PieceLength = 4
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "multi"), 0755))
+	for _, name := range []string{"a", "b", "c"} {
+		mi.Info.Files = append(mi.Info.Files, metainfo.FileInfo{
+			Length: int64(len(contents[name])),
+			Path:   []string{name},
+		})
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "multi", name), []byte(contents[name]), 0644))
+	}
+	require.NoError(t, mi.Info.GeneratePieces(func(fi metainfo.FileInfo) (io.ReadCloser, error) {
+		return ioutil.NopCloser(strings.NewReader(contents[fi.Path[0]])), nil
+	}))
+	mi.Info.UpdateBytes()
+	cfg := TestingConfig
+	cfg.DataDir = dir
+	cl, err := NewClient(&cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, err := cl.AddTorrent(mi)
+	require.NoError(t, err)
+	f := tt.Files()[1]
+	r := f.NewReader()
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "world", string(b))
+	off, err := r.Seek(-2, os.SEEK_END)
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, off)
+	b, err = ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "ld", string(b))
+	// The file's data is in pieces 1 and 2. Readahead doesn't reach past
+	// it.
+	r.Seek(0, os.SEEK_SET)
+	cl.mu.Lock()
+	assert.Equal(t, []int{1, 2}, tt.readerPieces().ToSortedSlice())
+	cl.mu.Unlock()
+	r.SetReadahead(1)
+	cl.mu.Lock()
+	assert.Equal(t, []int{1}, tt.readerPieces().ToSortedSlice())
+	cl.mu.Unlock()
+}
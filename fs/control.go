@@ -0,0 +1,291 @@
+package torrentfs
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	"bazil.org/fuse"
+	fusefs "bazil.org/fuse/fs"
+	"golang.org/x/net/context"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// The root of the filesystem has these besides a node for each torrent with
+// its info, named by the torrent:
+//
+//	.control/                 files written here are added as torrents
+//	.by-infohash/<hex>/       a directory for each torrent in the client
+//	.by-infohash/<hex>/data   the torrent's data, once the info is known
+//	.by-infohash/<hex>/name   the torrent's name
+//	.by-infohash/<hex>/progress
+//	                          bytes completed and the total length
+//	.by-infohash/<hex>/peers  a line for each connected peer
+//
+// A file written to .control is parsed when it's closed, as metainfo, or if
+// it starts with "magnet:", as magnet links separated by whitespace. It
+// doesn't remain. Removing a torrent's node in the root, or its directory in
+// .by-infohash, drops the torrent from the client.
+const (
+	controlDirName    = ".control"
+	byInfohashDirName = ".by-infohash"
+)
+
+var (
+	_ fusefs.NodeRemover        = rootNode{}
+	_ fusefs.NodeCreater        = controlDirNode{}
+	_ fusefs.HandleWriter       = &controlFile{}
+	_ fusefs.HandleFlusher      = &controlFile{}
+	_ fusefs.NodeRemover        = byInfohashDirNode{}
+	_ fusefs.HandleReadAller    = statusFileHandle{}
+	_ fusefs.NodeStringLookuper = torrentDirNode{}
+)
+
+func (rn rootNode) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
+	if req.Name == controlDirName || req.Name == byInfohashDirName {
+		return fuse.EPERM
+	}
+	for _, t := range rn.fs.Client.Torrents() {
+		if t.Info() == nil || t.Name() != req.Name {
+			continue
+		}
+		t.Drop()
+		return nil
+	}
+	return fuse.ENOENT
+}
+
+type controlDirNode struct {
+	fs *TorrentFS
+}
+
+func (cn controlDirNode) Attr(ctx context.Context, attr *fuse.Attr) error {
+	attr.Mode = os.ModeDir | 0755
+	return nil
+}
+
+func (cn controlDirNode) Lookup(ctx context.Context, name string) (fusefs.Node, error) {
+	return nil, fuse.ENOENT
+}
+
+func (cn controlDirNode) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	return nil, nil
+}
+
+func (cn controlDirNode) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.CreateResponse) (fusefs.Node, fusefs.Handle, error) {
+	f := &controlFile{fs: cn.fs}
+	return f, f, nil
+}
+
+// A file being written to the control directory. It's both the node and the
+// handle.
+type controlFile struct {
+	fs    *TorrentFS
+	mu    sync.Mutex
+	data  []byte
+	dirty bool
+}
+
+func (cf *controlFile) Attr(ctx context.Context, attr *fuse.Attr) error {
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+	attr.Mode = 0644
+	attr.Size = uint64(len(cf.data))
+	return nil
+}
+
+func (cf *controlFile) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+	end := int(req.Offset) + len(req.Data)
+	if end > len(cf.data) {
+		cf.data = append(cf.data, make([]byte, end-len(cf.data))...)
+	}
+	resp.Size = copy(cf.data[req.Offset:], req.Data)
+	cf.dirty = true
+	return nil
+}
+
+// Adds what's been written when the file is closed, so that errors are
+// returned to close.
+func (cf *controlFile) Flush(ctx context.Context, req *fuse.FlushRequest) error {
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+	if !cf.dirty {
+		return nil
+	}
+	cf.dirty = false
+	return addTorrents(cf.fs.Client, cf.data)
+}
+
+func addTorrents(cl *torrent.Client, b []byte) error {
+	if !bytes.HasPrefix(bytes.TrimSpace(b), []byte("magnet:")) {
+		mi, err := metainfo.Load(bytes.NewReader(b))
+		if err != nil {
+			return fuse.Errno(syscall.EINVAL)
+		}
+		_, err = cl.AddTorrent(mi)
+		if err != nil {
+			return fuse.EIO
+		}
+		return nil
+	}
+	s := bufio.NewScanner(bytes.NewReader(b))
+	s.Split(bufio.ScanWords)
+	for s.Scan() {
+		// Magnet links can be "commented" out, as in a watched directory.
+		if strings.HasPrefix(s.Text(), "#") {
+			continue
+		}
+		_, err := cl.AddMagnet(s.Text())
+		if err != nil {
+			return fuse.Errno(syscall.EINVAL)
+		}
+	}
+	return nil
+}
+
+type byInfohashDirNode struct {
+	fs *TorrentFS
+}
+
+func (bn byInfohashDirNode) Attr(ctx context.Context, attr *fuse.Attr) error {
+	attr.Mode = os.ModeDir | 0755
+	return nil
+}
+
+func (bn byInfohashDirNode) torrent(name string) (t *torrent.Torrent, ok bool) {
+	var ih metainfo.Hash
+	if ih.FromHexString(name) != nil {
+		return
+	}
+	return bn.fs.Client.Torrent(ih)
+}
+
+func (bn byInfohashDirNode) Lookup(ctx context.Context, name string) (fusefs.Node, error) {
+	t, ok := bn.torrent(name)
+	if !ok {
+		return nil, fuse.ENOENT
+	}
+	return torrentDirNode{bn.fs, t}, nil
+}
+
+func (bn byInfohashDirNode) ReadDirAll(ctx context.Context) (des []fuse.Dirent, err error) {
+	for _, t := range bn.fs.Client.Torrents() {
+		des = append(des, fuse.Dirent{
+			Name: t.InfoHash().HexString(),
+			Type: fuse.DT_Dir,
+		})
+	}
+	return
+}
+
+func (bn byInfohashDirNode) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
+	t, ok := bn.torrent(req.Name)
+	if !ok {
+		return fuse.ENOENT
+	}
+	t.Drop()
+	return nil
+}
+
+// A torrent's directory in .by-infohash.
+type torrentDirNode struct {
+	fs *TorrentFS
+	t  *torrent.Torrent
+}
+
+var statusFiles = map[string]func(*torrent.Torrent) []byte{
+	"name": func(t *torrent.Torrent) []byte {
+		return []byte(t.Name() + "\n")
+	},
+	"progress": func(t *torrent.Torrent) []byte {
+		if t.Info() == nil {
+			return []byte(fmt.Sprintf("%d -1\n", t.BytesCompleted()))
+		}
+		return []byte(fmt.Sprintf("%d %d\n", t.BytesCompleted(), t.Length()))
+	},
+	"peers": func(t *torrent.Torrent) []byte {
+		var buf bytes.Buffer
+		for _, pc := range t.PeerConns() {
+			fmt.Fprintf(&buf, "%s\t%q\t%.0f\t%.0f\n", pc.RemoteAddr, pc.ClientName, pc.DownloadRate, pc.UploadRate)
+		}
+		return buf.Bytes()
+	},
+}
+
+func (tn torrentDirNode) Attr(ctx context.Context, attr *fuse.Attr) error {
+	attr.Mode = os.ModeDir | defaultMode
+	return nil
+}
+
+func (tn torrentDirNode) Lookup(ctx context.Context, name string) (fusefs.Node, error) {
+	if name == "data" {
+		info := tn.t.Info()
+		if info == nil {
+			return nil, fuse.ENOENT
+		}
+		return torrentNode(tn.fs, tn.t, info), nil
+	}
+	if _, ok := statusFiles[name]; ok {
+		return statusFileNode{tn.t, name}, nil
+	}
+	return nil, fuse.ENOENT
+}
+
+func (tn torrentDirNode) ReadDirAll(ctx context.Context) (des []fuse.Dirent, err error) {
+	if info := tn.t.Info(); info != nil {
+		des = append(des, fuse.Dirent{
+			Name: "data",
+			Type: torrentDirentType(info),
+		})
+	}
+	var names []string
+	for name := range statusFiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		des = append(des, fuse.Dirent{
+			Name: name,
+			Type: fuse.DT_File,
+		})
+	}
+	return
+}
+
+// A file with contents generated from the torrent's state when it's opened.
+// Nodes must be comparable, so it's keyed into statusFiles by name.
+type statusFileNode struct {
+	t    *torrent.Torrent
+	name string
+}
+
+func (sn statusFileNode) contents() []byte {
+	return statusFiles[sn.name](sn.t)
+}
+
+func (sn statusFileNode) Attr(ctx context.Context, attr *fuse.Attr) error {
+	attr.Mode = 0444
+	attr.Size = uint64(len(sn.contents()))
+	return nil
+}
+
+func (sn statusFileNode) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fusefs.Handle, error) {
+	// The size can change between the attributes and reading.
+	resp.Flags |= fuse.OpenDirectIO
+	return statusFileHandle(sn.contents()), nil
+}
+
+type statusFileHandle []byte
+
+func (sh statusFileHandle) ReadAll(ctx context.Context) ([]byte, error) {
+	return sh, nil
+}
@@ -0,0 +1,77 @@
+package torrentfs
+
+import (
+	"bytes"
+	"syscall"
+	"testing"
+
+	"bazil.org/fuse"
+	fusefs "bazil.org/fuse/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	netContext "golang.org/x/net/context"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/internal/testutil"
+)
+
+func TestControlDir(t *testing.T) {
+	layout, err := newGreetingLayout()
+	require.NoError(t, err)
+	defer layout.Destroy()
+	cl, err := torrent.NewClient(&torrent.Config{
+		DataDir:         layout.Completed,
+		DisableTrackers: true,
+		NoDHT:           true,
+		DisableTCP:      true,
+		DisableUTP:      true,
+	})
+	require.NoError(t, err)
+	defer cl.Close()
+	fs := New(cl)
+	defer fs.Destroy()
+	ctx := netContext.Background()
+	root, _ := fs.Root()
+	lookup := func(n fusefs.Node, name string) fusefs.Node {
+		ret, err := n.(fusefs.NodeStringLookuper).Lookup(ctx, name)
+		require.NoError(t, err)
+		return ret
+	}
+
+	ctl := lookup(root, controlDirName)
+	_, h, err := ctl.(fusefs.NodeCreater).Create(ctx, &fuse.CreateRequest{Name: "greeting.torrent"}, &fuse.CreateResponse{})
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, layout.Metainfo.Write(&buf))
+	require.NoError(t, h.(fusefs.HandleWriter).Write(ctx, &fuse.WriteRequest{Data: buf.Bytes()}, &fuse.WriteResponse{}))
+	require.NoError(t, h.(fusefs.HandleFlusher).Flush(ctx, &fuse.FlushRequest{}))
+	require.Len(t, cl.Torrents(), 1)
+	tt := cl.Torrents()[0]
+	<-tt.GotInfo()
+
+	_, h, err = ctl.(fusefs.NodeCreater).Create(ctx, &fuse.CreateRequest{Name: "junk"}, &fuse.CreateResponse{})
+	require.NoError(t, err)
+	require.NoError(t, h.(fusefs.HandleWriter).Write(ctx, &fuse.WriteRequest{Data: []byte("junk")}, &fuse.WriteResponse{}))
+	assert.EqualValues(t, fuse.Errno(syscall.EINVAL), h.(fusefs.HandleFlusher).Flush(ctx, &fuse.FlushRequest{}))
+
+	dir := lookup(lookup(root, byInfohashDirName), tt.InfoHash().HexString())
+	des, err := dir.(fusefs.HandleReadDirAller).ReadDirAll(ctx)
+	require.NoError(t, err)
+	var names []string
+	for _, de := range des {
+		names = append(names, de.Name)
+	}
+	assert.EqualValues(t, []string{"data", "name", "peers", "progress"}, names)
+	h, err = lookup(dir, "name").(fusefs.NodeOpener).Open(ctx, &fuse.OpenRequest{}, &fuse.OpenResponse{})
+	require.NoError(t, err)
+	b, err := h.(fusefs.HandleReadAller).ReadAll(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "greeting\n", string(b))
+	resp := fuse.ReadResponse{Data: make([]byte, 100)}
+	err = lookup(dir, "data").(fusefs.HandleReader).Read(ctx, &fuse.ReadRequest{Size: 100}, &resp)
+	require.NoError(t, err)
+	assert.Equal(t, testutil.GreetingFileContents, string(resp.Data))
+
+	require.NoError(t, root.(fusefs.NodeRemover).Remove(ctx, &fuse.RemoveRequest{Name: "greeting"}))
+	assert.Empty(t, cl.Torrents())
+}
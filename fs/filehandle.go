@@ -0,0 +1,159 @@
+package torrentfs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"bazil.org/fuse"
+	fusefs "bazil.org/fuse/fs"
+	"golang.org/x/net/context"
+
+	"github.com/anacrolix/torrent"
+)
+
+const (
+	defaultMaxReadahead = 10 << 20
+	// The readahead for an open file that isn't being read sequentially.
+	minReadahead = 128 << 10
+)
+
+var (
+	_ fusefs.HandleReader   = &fileHandle{}
+	_ fusefs.HandleReleaser = &fileHandle{}
+)
+
+func (tfs *TorrentFS) maxReadahead() int64 {
+	if tfs.MaxReadahead > 0 {
+		return tfs.MaxReadahead
+	}
+	return defaultMaxReadahead
+}
+
+// An open file. It keeps a Reader for its lifetime, so the priorities of the
+// pieces around its position persist between reads. While reads follow on
+// from each other, the readahead doubles up to the TorrentFS MaxReadahead.
+type fileHandle struct {
+	fn fileNode
+	// Serializes reads, as they move the Reader.
+	mu        sync.Mutex
+	r         *torrent.Reader
+	readahead int64
+}
+
+func (fn fileNode) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fusefs.Handle, error) {
+	return fn.open(), nil
+}
+
+func (fn fileNode) open() *fileHandle {
+	fh := &fileHandle{
+		fn:        fn,
+		r:         fn.t.Files()[fn.fileIndex].NewReader(),
+		readahead: minReadahead,
+	}
+	fh.r.SetReadahead(fh.readahead)
+	return fh
+}
+
+func (fh *fileHandle) release() {
+	fh.r.Close()
+}
+
+func (fh *fileHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	fh.release()
+	return nil
+}
+
+func (fh *fileHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	torrentfsReadRequests.Add(1)
+	if req.Dir {
+		panic("read on directory")
+	}
+	size := req.Size
+	fileLeft := int64(fh.fn.size) - req.Offset
+	if fileLeft < 0 {
+		fileLeft = 0
+	}
+	if fileLeft < int64(size) {
+		size = int(fileLeft)
+	}
+	resp.Data = resp.Data[:size]
+	if len(resp.Data) == 0 {
+		return nil
+	}
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+	fh.seek(req.Offset)
+	n, err := fh.readFull(ctx, resp.Data)
+	if err != nil {
+		return err
+	}
+	if n != size {
+		panic(fmt.Sprintf("%d < %d", n, size))
+	}
+	return nil
+}
+
+// Moves the Reader to off. The readahead grows if the read follows on from
+// the last one, and otherwise starts over.
+func (fh *fileHandle) seek(off int64) {
+	pos, _ := fh.r.Seek(0, os.SEEK_CUR)
+	if off == pos {
+		if fh.readahead < fh.fn.FS.maxReadahead() {
+			fh.readahead *= 2
+			if max := fh.fn.FS.maxReadahead(); fh.readahead > max {
+				fh.readahead = max
+			}
+			fh.r.SetReadahead(fh.readahead)
+		}
+		return
+	}
+	fh.r.Seek(off, os.SEEK_SET)
+	if fh.readahead != minReadahead {
+		fh.readahead = minReadahead
+		fh.r.SetReadahead(fh.readahead)
+	}
+}
+
+// Reads until p is full. The read is abandoned if the request is
+// interrupted, or the filesystem is destroyed.
+func (fh *fileHandle) readFull(ctx context.Context, p []byte) (n int, err error) {
+	fs := fh.fn.FS
+	fs.mu.Lock()
+	fs.blockedReads++
+	fs.event.Broadcast()
+	fs.mu.Unlock()
+	defer func() {
+		fs.mu.Lock()
+		fs.blockedReads--
+		fs.event.Broadcast()
+		fs.mu.Unlock()
+	}()
+	readCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-fs.destroyed:
+			cancel()
+		case <-readCtx.Done():
+		}
+	}()
+	n, err = fh.r.ReadContext(p, readCtx)
+	if n == len(p) {
+		return n, nil
+	}
+	select {
+	case <-fs.destroyed:
+		return n, fuse.EIO
+	default:
+	}
+	if ctx.Err() != nil {
+		interruptedReads.Add(1)
+		return n, fuse.EINTR
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return
+}
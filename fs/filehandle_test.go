@@ -0,0 +1,56 @@
+package torrentfs
+
+import (
+	"testing"
+
+	"bazil.org/fuse"
+	fusefs "bazil.org/fuse/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	netContext "golang.org/x/net/context"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/internal/testutil"
+)
+
+func TestFileHandleReadahead(t *testing.T) {
+	layout, err := newGreetingLayout()
+	require.NoError(t, err)
+	defer layout.Destroy()
+	cl, err := torrent.NewClient(&torrent.Config{
+		DataDir:         layout.Completed,
+		DisableTrackers: true,
+		NoDHT:           true,
+		DisableTCP:      true,
+		DisableUTP:      true,
+	})
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, err := cl.AddTorrent(layout.Metainfo)
+	require.NoError(t, err)
+	<-tt.GotInfo()
+	fs := New(cl)
+	defer fs.Destroy()
+	fs.MaxReadahead = 3 * minReadahead
+	ctx := netContext.Background()
+	root, _ := fs.Root()
+	node, err := root.(fusefs.NodeStringLookuper).Lookup(ctx, "greeting")
+	require.NoError(t, err)
+	h, err := node.(fusefs.NodeOpener).Open(ctx, &fuse.OpenRequest{}, &fuse.OpenResponse{})
+	require.NoError(t, err)
+	fh := h.(*fileHandle)
+	defer fh.Release(ctx, &fuse.ReleaseRequest{})
+	read := func(off int64, size int) string {
+		resp := fuse.ReadResponse{Data: make([]byte, size)}
+		require.NoError(t, fh.Read(ctx, &fuse.ReadRequest{Offset: off, Size: size}, &resp))
+		return string(resp.Data)
+	}
+	assert.Equal(t, "hello", read(0, 5))
+	assert.EqualValues(t, 2*minReadahead, fh.readahead)
+	assert.Equal(t, ", wor", read(5, 5))
+	assert.EqualValues(t, 3*minReadahead, fh.readahead)
+	assert.Equal(t, "ld\n", read(10, 5))
+	assert.EqualValues(t, 3*minReadahead, fh.readahead)
+	assert.Equal(t, testutil.GreetingFileContents, read(0, 100))
+	assert.EqualValues(t, minReadahead, fh.readahead)
+}
@@ -2,8 +2,6 @@ package torrentfs
 
 import (
 	"expvar"
-	"fmt"
-	"io"
 	"os"
 	"path"
 	"strings"
@@ -28,7 +26,10 @@ var (
 )
 
 type TorrentFS struct {
-	Client       *torrent.Client
+	Client *torrent.Client
+	// The most that the readahead of an open file grows to while it's read
+	// sequentially. Defaults to 10 MiB.
+	MaxReadahead int64
 	destroyed    chan struct{}
 	mu           sync.Mutex
 	blockedReads int
@@ -58,6 +59,8 @@ type fileNode struct {
 	node
 	size          uint64
 	TorrentOffset int64
+	// Index into the torrent's Files.
+	fileIndex int
 }
 
 func (fn fileNode) Attr(ctx context.Context, attr *fuse.Attr) error {
@@ -70,82 +73,11 @@ func (n *node) fsPath() string {
 	return "/" + n.metadata.Name + "/" + n.path
 }
 
-func blockingRead(ctx context.Context, fs *TorrentFS, t *torrent.Torrent, off int64, p []byte) (n int, err error) {
-	fs.mu.Lock()
-	fs.blockedReads++
-	fs.event.Broadcast()
-	fs.mu.Unlock()
-	var (
-		_n   int
-		_err error
-	)
-	readDone := make(chan struct{})
-	go func() {
-		defer close(readDone)
-		r := t.NewReader()
-		defer r.Close()
-		_, _err = r.Seek(off, os.SEEK_SET)
-		if _err != nil {
-			return
-		}
-		_n, _err = io.ReadFull(r, p)
-	}()
-	select {
-	case <-readDone:
-		n = _n
-		err = _err
-	case <-fs.destroyed:
-		err = fuse.EIO
-	case <-ctx.Done():
-		err = fuse.EINTR
-	}
-	fs.mu.Lock()
-	fs.blockedReads--
-	fs.event.Broadcast()
-	fs.mu.Unlock()
-	return
-}
-
-func readFull(ctx context.Context, fs *TorrentFS, t *torrent.Torrent, off int64, p []byte) (n int, err error) {
-	for len(p) != 0 {
-		var nn int
-		nn, err = blockingRead(ctx, fs, t, off, p)
-		if err != nil {
-			break
-		}
-		n += nn
-		off += int64(nn)
-		p = p[nn:]
-	}
-	return
-}
-
 func (fn fileNode) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
-	torrentfsReadRequests.Add(1)
-	if req.Dir {
-		panic("read on directory")
-	}
-	size := req.Size
-	fileLeft := int64(fn.size) - req.Offset
-	if fileLeft < 0 {
-		fileLeft = 0
-	}
-	if fileLeft < int64(size) {
-		size = int(fileLeft)
-	}
-	resp.Data = resp.Data[:size]
-	if len(resp.Data) == 0 {
-		return nil
-	}
-	torrentOff := fn.TorrentOffset + req.Offset
-	n, err := readFull(ctx, fn.FS, fn.t, torrentOff, resp.Data)
-	if err != nil {
-		return err
-	}
-	if n != size {
-		panic(fmt.Sprintf("%d < %d", n, size))
-	}
-	return nil
+	// Reads without an open handle, such as by calling the node directly.
+	fh := fn.open()
+	defer fh.release()
+	return fh.Read(ctx, req, resp)
 }
 
 type dirNode struct {
@@ -155,6 +87,7 @@ type dirNode struct {
 var (
 	_ fusefs.HandleReadDirAller = dirNode{}
 	_ fusefs.HandleReader       = fileNode{}
+	_ fusefs.NodeOpener         = fileNode{}
 )
 
 func isSubPath(parent, child string) bool {
@@ -194,7 +127,7 @@ func (dn dirNode) ReadDirAll(ctx context.Context) (des []fuse.Dirent, err error)
 
 func (dn dirNode) Lookup(ctx context.Context, name string) (_node fusefs.Node, err error) {
 	var torrentOffset int64
-	for _, fi := range dn.metadata.Files {
+	for i, fi := range dn.metadata.Files {
 		if !isSubPath(dn.path, strings.Join(fi.Path, "/")) {
 			torrentOffset += fi.Length
 			continue
@@ -210,6 +143,7 @@ func (dn dirNode) Lookup(ctx context.Context, name string) (_node fusefs.Node, e
 				node:          __node,
 				size:          uint64(fi.Length),
 				TorrentOffset: torrentOffset,
+				fileIndex:     i,
 			}
 		} else {
 			_node = dirNode{__node}
@@ -227,22 +161,39 @@ func (dn dirNode) Attr(ctx context.Context, attr *fuse.Attr) error {
 	return nil
 }
 
+// Returns the node for the root of the torrent's data.
+func torrentNode(fs *TorrentFS, t *torrent.Torrent, info *metainfo.InfoEx) fusefs.Node {
+	__node := node{
+		metadata: info,
+		FS:       fs,
+		t:        t,
+	}
+	if !info.IsDir() {
+		return fileNode{__node, uint64(info.Length), 0, 0}
+	}
+	return dirNode{__node}
+}
+
+func torrentDirentType(info *metainfo.InfoEx) fuse.DirentType {
+	if !info.IsDir() {
+		return fuse.DT_File
+	}
+	return fuse.DT_Dir
+}
+
 func (rn rootNode) Lookup(ctx context.Context, name string) (_node fusefs.Node, err error) {
+	switch name {
+	case controlDirName:
+		return controlDirNode{rn.fs}, nil
+	case byInfohashDirName:
+		return byInfohashDirNode{rn.fs}, nil
+	}
 	for _, t := range rn.fs.Client.Torrents() {
 		info := t.Info()
 		if t.Name() != name || info == nil {
 			continue
 		}
-		__node := node{
-			metadata: info,
-			FS:       rn.fs,
-			t:        t,
-		}
-		if !info.IsDir() {
-			_node = fileNode{__node, uint64(info.Length), 0}
-		} else {
-			_node = dirNode{__node}
-		}
+		_node = torrentNode(rn.fs, t, info)
 		break
 	}
 	if _node == nil {
@@ -252,6 +203,10 @@ func (rn rootNode) Lookup(ctx context.Context, name string) (_node fusefs.Node,
 }
 
 func (rn rootNode) ReadDirAll(ctx context.Context) (dirents []fuse.Dirent, err error) {
+	dirents = []fuse.Dirent{
+		{Name: controlDirName, Type: fuse.DT_Dir},
+		{Name: byInfohashDirName, Type: fuse.DT_Dir},
+	}
 	for _, t := range rn.fs.Client.Torrents() {
 		info := t.Info()
 		if info == nil {
@@ -259,13 +214,7 @@ func (rn rootNode) ReadDirAll(ctx context.Context) (dirents []fuse.Dirent, err e
 		}
 		dirents = append(dirents, fuse.Dirent{
 			Name: info.Name,
-			Type: func() fuse.DirentType {
-				if !info.IsDir() {
-					return fuse.DT_File
-				} else {
-					return fuse.DT_Dir
-				}
-			}(),
+			Type: torrentDirentType(info),
 		})
 	}
 	return
@@ -57,12 +57,18 @@ var (
 	unwantedChunksReceived   = expvar.NewInt("chunksReceivedUnwanted")
 	unexpectedChunksReceived = expvar.NewInt("chunksReceivedUnexpected")
 	chunksReceived           = expvar.NewInt("chunksReceived")
+	// Bytes of chunks received that were no longer wanted.
+	chunkBytesWasted = expvar.NewInt("chunkBytesWasted")
+	// Requests sent for chunks already requested from another peer.
+	endGameRequests = expvar.NewInt("endGameRequests")
 
 	peersAddedBySource = expvar.NewMap("peersAddedBySource")
 
 	uploadChunksPosted = expvar.NewInt("uploadChunksPosted")
 	unexpectedCancels  = expvar.NewInt("unexpectedCancels")
 	postedCancels      = expvar.NewInt("postedCancels")
+	snubbedPeers       = expvar.NewInt("snubbedPeers")
+	unsnubbedPeers     = expvar.NewInt("unsnubbedPeers")
 
 	pieceHashedCorrect    = expvar.NewInt("pieceHashedCorrect")
 	pieceHashedNotCorrect = expvar.NewInt("pieceHashedNotCorrect")
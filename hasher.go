@@ -0,0 +1,66 @@
+package torrent
+
+import (
+	"io"
+	"runtime"
+	"sync"
+	"time"
+)
+
+type pieceHashItem struct {
+	t     *Torrent
+	piece int
+}
+
+func (cl *Client) maxHashers() int {
+	if cl.config.HashWorkers > 0 {
+		return cl.config.HashWorkers
+	}
+	return runtime.NumCPU()
+}
+
+// Works through the Client's hash queue, and exits when it's empty.
+func (cl *Client) pieceHasher() {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	for len(cl.hashQueue) != 0 {
+		item := cl.hashQueue[0]
+		cl.hashQueue = cl.hashQueue[1:]
+		cl.verifyPiece(item.t, item.piece)
+	}
+	cl.activeHashers--
+}
+
+// Spreads reads across time so that the total rate doesn't exceed rate bytes
+// per second.
+type rateLimiter struct {
+	rate int64
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// Accounts for n bytes, sleeping until the bytes before them fit within the
+// rate.
+func (rl *rateLimiter) wait(n int) {
+	rl.mu.Lock()
+	now := time.Now()
+	if rl.next.Before(now) {
+		rl.next = now
+	}
+	delay := rl.next.Sub(now)
+	rl.next = rl.next.Add(time.Duration(int64(n) * int64(time.Second) / rl.rate))
+	rl.mu.Unlock()
+	time.Sleep(delay)
+}
+
+type rateLimitedReader struct {
+	r  io.Reader
+	rl *rateLimiter
+}
+
+func (me rateLimitedReader) Read(b []byte) (n int, err error) {
+	n, err = me.r.Read(b)
+	me.rl.wait(n)
+	return
+}
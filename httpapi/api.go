@@ -0,0 +1,147 @@
+// Package httpapi provides a JSON over HTTP API for managing a running
+// Client, and a client for it. All paths begin with the API version:
+//
+//	GET    /v1/torrents                   list the torrents
+//	POST   /v1/torrents                   add a torrent, see AddRequest
+//	GET    /v1/torrents/<infohash>        a torrent, with its files
+//	DELETE /v1/torrents/<infohash>        drop a torrent
+//	POST   /v1/torrents/<infohash>/pause  stop a torrent, see Torrent.Pause
+//	POST   /v1/torrents/<infohash>/resume restart a paused torrent
+//	PUT    /v1/torrents/<infohash>/queue  move a torrent in the queue, see QueuePosition
+//	GET    /v1/torrents/<infohash>/peers  the torrent's peer connections
+//	GET    /v1/torrents/<infohash>/trackers
+//	                                      the torrent's announce-list
+//	PUT    /v1/torrents/<infohash>/files/<index>
+//	                                      set a file's priority, see FilePriority
+//	GET    /v1/settings                   the Client's settings
+//	PUT    /v1/settings                   change the Client's settings
+//	GET    /v1/events                     a stream of Events
+//
+// Errors have a status code, and an Error body. Events are sent as
+// server-sent events, with the Event type as the event name, and the Event as
+// the data.
+package httpapi
+
+import (
+	"time"
+)
+
+type Torrent struct {
+	InfoHash string `json:"infohash"`
+	Name     string `json:"name"`
+	// One of "getting info", "checking", "downloading", "stalled",
+	// "seeding", "complete", "queued", "finished", "stopped" or "error".
+	State  string `json:"state"`
+	Paused bool   `json:"paused"`
+	// Waiting in the queue, or done seeding. See Settings.
+	Queued        bool `json:"queued"`
+	QueuePosition int  `json:"queue_position"`
+	// The rest of the torrent's fields are valid once this is set.
+	HaveInfo       bool  `json:"have_info"`
+	Length         int64 `json:"length"`
+	BytesCompleted int64 `json:"bytes_completed"`
+	BytesUploaded  int64 `json:"bytes_uploaded"`
+	BytesWasted    int64 `json:"bytes_wasted"`
+	Seeding        bool  `json:"seeding"`
+	Peers          int   `json:"peers"`
+	// Only given for a single torrent.
+	Files []File `json:"files,omitempty"`
+}
+
+type File struct {
+	Path           string `json:"path"`
+	Offset         int64  `json:"offset"`
+	Length         int64  `json:"length"`
+	BytesCompleted int64  `json:"bytes_completed"`
+}
+
+// The body to add a torrent. One of the fields is given. Metainfo can also
+// be posted as is, with the Content-Type application/x-bittorrent. Added
+// torrents are downloaded in full, unless file priorities are changed.
+// Adding a torrent that's already there leaves it as it is.
+type AddRequest struct {
+	Magnet string `json:"magnet,omitempty"`
+	// The torrent's metainfo is fetched from here.
+	URL      string `json:"url,omitempty"`
+	Metainfo []byte `json:"metainfo,omitempty"`
+}
+
+// The body to set a file's priority. Priority is one of "default", "skip",
+// "low", "normal" or "high".
+type FilePriority struct {
+	Priority string `json:"priority"`
+}
+
+type Peer struct {
+	Addr           string    `json:"addr"`
+	PeerID         string    `json:"peer_id"`
+	ClientName     string    `json:"client_name,omitempty"`
+	Source         string    `json:"source"`
+	Encrypted      bool      `json:"encrypted"`
+	UTP            bool      `json:"utp"`
+	Connected      time.Time `json:"connected"`
+	Interested     bool      `json:"interested"`
+	Choked         bool      `json:"choked"`
+	PeerInterested bool      `json:"peer_interested"`
+	PeerChoked     bool      `json:"peer_choked"`
+	Snubbed        bool      `json:"snubbed"`
+	DownloadRate   float64   `json:"download_rate"`
+	UploadRate     float64   `json:"upload_rate"`
+}
+
+// The body to move a torrent in the queue. Positions start from 0.
+type QueuePosition struct {
+	Position int `json:"position"`
+}
+
+// Only the connection and queue limits can be changed. A PUT can give just
+// the fields to change. Zero limits are unlimited.
+type Settings struct {
+	PeerID             string  `json:"peer_id"`
+	ListenAddr         string  `json:"listen_addr"`
+	MaxConns           int     `json:"max_conns"`
+	MaxHalfOpen        int     `json:"max_half_open"`
+	MaxPendingAccepts  int     `json:"max_pending_accepts"`
+	MaxActiveDownloads int     `json:"max_active_downloads"`
+	MaxActiveSeeds     int     `json:"max_active_seeds"`
+	StallTimeout       int64   `json:"stall_timeout"` // In seconds.
+	SeedRatio          float64 `json:"seed_ratio"`
+	SeedTime           int64   `json:"seed_time"` // In seconds.
+}
+
+// The Types of Event.
+const (
+	// The torrent was added. Sent for each torrent when the stream starts.
+	EventAdded = "added"
+	// The torrent's info became available.
+	EventInfo  = "info"
+	EventPiece = "piece"
+	// The torrent's State changed.
+	EventState         = "state"
+	EventFileCompleted = "file_completed"
+	EventCompleted     = "completed"
+	EventRemoved       = "removed"
+)
+
+type Event struct {
+	Type     string `json:"type"`
+	InfoHash string `json:"infohash"`
+	// Given for EventPiece.
+	Piece *PieceEvent `json:"piece,omitempty"`
+	// The new state, for EventState.
+	State string `json:"state,omitempty"`
+	// The file's path, for EventFileCompleted.
+	File string `json:"file,omitempty"`
+}
+
+// A change in the state of a piece.
+type PieceEvent struct {
+	Index    int  `json:"index"`
+	Complete bool `json:"complete"`
+	Checking bool `json:"checking"`
+	Partial  bool `json:"partial"`
+}
+
+type Error struct {
+	Error string `json:"error"`
+}
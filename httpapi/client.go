@@ -0,0 +1,181 @@
+package httpapi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// A client for the API served by a Handler. Torrents are identified by their
+// infohash in hex.
+type Client struct {
+	// The base URL of the API, without the version, such as
+	// "http://localhost:6880".
+	URL string
+	// Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+	// Sent as a bearer token, if the Handler requires one.
+	Token string
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+func (c *Client) newRequest(method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, strings.TrimSuffix(c.URL, "/")+"/v1/"+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	return req, nil
+}
+
+// Sends the request, and decodes the response body into out, if it's not
+// nil.
+func (c *Client) do(method, path, contentType string, body io.Reader, out interface{}) error {
+	req, err := c.newRequest(method, path, body)
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var e Error
+		if json.NewDecoder(resp.Body).Decode(&e) != nil || e.Error == "" {
+			return fmt.Errorf("response status %q", resp.Status)
+		}
+		return errors.New(e.Error)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *Client) doJSON(method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	return c.do(method, path, "application/json", body, out)
+}
+
+func (c *Client) Torrents() (ret []Torrent, err error) {
+	err = c.doJSON("GET", "torrents", nil, &ret)
+	return
+}
+
+// Returns the torrent, with its files.
+func (c *Client) Torrent(infoHash string) (ret Torrent, err error) {
+	err = c.doJSON("GET", "torrents/"+infoHash, nil, &ret)
+	return
+}
+
+func (c *Client) AddTorrent(req AddRequest) (ret Torrent, err error) {
+	err = c.doJSON("POST", "torrents", req, &ret)
+	return
+}
+
+// Adds the torrent for the metainfo read from r.
+func (c *Client) AddMetainfo(r io.Reader) (ret Torrent, err error) {
+	err = c.do("POST", "torrents", "application/x-bittorrent", r, &ret)
+	return
+}
+
+func (c *Client) Drop(infoHash string) error {
+	return c.doJSON("DELETE", "torrents/"+infoHash, nil, nil)
+}
+
+func (c *Client) Pause(infoHash string) error {
+	return c.doJSON("POST", "torrents/"+infoHash+"/pause", nil, nil)
+}
+
+func (c *Client) Resume(infoHash string) error {
+	return c.doJSON("POST", "torrents/"+infoHash+"/resume", nil, nil)
+}
+
+// Moves the torrent to the position in the queue.
+func (c *Client) SetQueuePosition(infoHash string, position int) error {
+	return c.doJSON("PUT", "torrents/"+infoHash+"/queue", QueuePosition{position}, nil)
+}
+
+func (c *Client) Peers(infoHash string) (ret []Peer, err error) {
+	err = c.doJSON("GET", "torrents/"+infoHash+"/peers", nil, &ret)
+	return
+}
+
+func (c *Client) Trackers(infoHash string) (ret [][]string, err error) {
+	err = c.doJSON("GET", "torrents/"+infoHash+"/trackers", nil, &ret)
+	return
+}
+
+// Sets the priority of the file at the index in the torrent's Files. See
+// FilePriority.
+func (c *Client) SetFilePriority(infoHash string, index int, priority string) error {
+	return c.doJSON("PUT", fmt.Sprintf("torrents/%s/files/%d", infoHash, index), FilePriority{priority}, nil)
+}
+
+func (c *Client) Settings() (ret Settings, err error) {
+	err = c.doJSON("GET", "settings", nil, &ret)
+	return
+}
+
+// Changes the settings given by the fields of the JSON object, and returns
+// the settings after.
+func (c *Client) SetSettings(changes map[string]interface{}) (ret Settings, err error) {
+	err = c.doJSON("PUT", "settings", changes, &ret)
+	return
+}
+
+// Calls f with each Event from the stream, until f returns an error, or the
+// stream ends.
+func (c *Client) Events(f func(Event) error) error {
+	req, err := c.newRequest("GET", "events", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("response status %q", resp.Status)
+	}
+	s := bufio.NewScanner(resp.Body)
+	for s.Scan() {
+		data := strings.TrimPrefix(s.Text(), "data: ")
+		if data == s.Text() {
+			continue
+		}
+		var ev Event
+		if err := json.Unmarshal([]byte(data), &ev); err != nil {
+			return err
+		}
+		if err := f(ev); err != nil {
+			return err
+		}
+	}
+	return s.Err()
+}
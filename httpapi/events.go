@@ -0,0 +1,132 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/anacrolix/missinggo/pubsub"
+
+	"github.com/anacrolix/torrent"
+)
+
+func (h *Handler) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, errorf(http.StatusInternalServerError, "streaming unsupported"))
+		return
+	}
+	var closed <-chan bool
+	if cn, ok := w.(http.CloseNotifier); ok {
+		closed = cn.CloseNotify()
+	}
+	// Subscribe before listing the torrents, so none are missed.
+	sub := h.Client.SubscribeEvents()
+	defer sub.Close()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	write := func(ev Event) {
+		b, _ := json.Marshal(ev)
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, b)
+		flusher.Flush()
+	}
+	pieces := make(chan Event)
+	// Closed to stop the goroutine watching each torrent's pieces.
+	watching := make(map[*torrent.Torrent]chan struct{})
+	defer func() {
+		for _, stop := range watching {
+			close(stop)
+		}
+	}()
+	added := func(t *torrent.Torrent) {
+		if watching[t] != nil {
+			return
+		}
+		// Changes after the added Event aren't missed.
+		psub := t.SubscribePieceStateChanges()
+		write(Event{Type: EventAdded, InfoHash: t.InfoHash().HexString()})
+		stop := make(chan struct{})
+		watching[t] = stop
+		go watchPieces(t, psub, pieces, stop)
+	}
+	for _, t := range h.Client.Torrents() {
+		added(t)
+	}
+	for {
+		select {
+		case v, ok := <-sub.Values:
+			if !ok {
+				return
+			}
+			tev := v.(torrent.TorrentEvent)
+			t := tev.Torrent
+			if tev.Type == torrent.TorrentAdded {
+				added(t)
+				continue
+			}
+			if watching[t] == nil {
+				continue
+			}
+			ev := Event{InfoHash: t.InfoHash().HexString()}
+			switch tev.Type {
+			case torrent.TorrentGotInfo:
+				ev.Type = EventInfo
+			case torrent.TorrentStateChanged:
+				ev.Type = EventState
+				ev.State = tev.State.String()
+			case torrent.TorrentFileCompleted:
+				ev.Type = EventFileCompleted
+				ev.File = tev.File.DisplayPath()
+			case torrent.TorrentCompleted:
+				ev.Type = EventCompleted
+			case torrent.TorrentDropped:
+				close(watching[t])
+				delete(watching, t)
+				ev.Type = EventRemoved
+			}
+			write(ev)
+		case ev := <-pieces:
+			write(ev)
+		case <-closed:
+			return
+		}
+	}
+}
+
+// Sends an EventPiece for each change from the torrent's piece
+// subscription, until stop is closed.
+func watchPieces(t *torrent.Torrent, sub *pubsub.Subscription, events chan<- Event, stop <-chan struct{}) {
+	ih := t.InfoHash().HexString()
+	defer sub.Close()
+	for {
+		var (
+			v  interface{}
+			ok bool
+		)
+		select {
+		case v, ok = <-sub.Values:
+			if !ok {
+				return
+			}
+		case <-stop:
+			return
+		}
+		psc := v.(torrent.PieceStateChange)
+		select {
+		case events <- Event{
+			Type:     EventPiece,
+			InfoHash: ih,
+			Piece: &PieceEvent{
+				Index:    psc.Index,
+				Complete: psc.Complete,
+				Checking: psc.Checking,
+				Partial:  psc.Partial,
+			},
+		}:
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,406 @@
+package httpapi
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+var priorities = map[string]torrent.DownloadPriority{
+	"default": torrent.DownloadPriorityDefault,
+	"skip":    torrent.DownloadPrioritySkip,
+	"low":     torrent.DownloadPriorityLow,
+	"normal":  torrent.DownloadPriorityNormal,
+	"high":    torrent.DownloadPriorityHigh,
+}
+
+// Serves the API for a Client. The API can add torrents, including from
+// URLs fetched by the Client, and change its settings, so it shouldn't be
+// reachable by untrusted users without a Token.
+type Handler struct {
+	Client *torrent.Client
+	// If not empty, requests must give it as a bearer token in the
+	// Authorization header.
+	Token string
+}
+
+// How long fetching a metainfo for a URL can take.
+const fetchTimeout = time.Minute
+
+var fetchClient = &http.Client{Timeout: fetchTimeout}
+
+// An error response.
+type httpError struct {
+	code int
+	msg  string
+}
+
+func (me httpError) Error() string {
+	return me.msg
+}
+
+func errorf(code int, format string, a ...interface{}) error {
+	return httpError{code, fmt.Sprintf(format, a...)}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	if he, ok := err.(httpError); ok {
+		code = he.code
+	}
+	writeJSON(w, code, Error{err.Error()})
+}
+
+func readJSON(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return errorf(http.StatusBadRequest, "error decoding body: %s", err)
+	}
+	return nil
+}
+
+func (h *Handler) authorized(r *http.Request) bool {
+	if h.Token == "" {
+		return true
+	}
+	want := "Bearer " + h.Token
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(want)) == 1
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, errorf(http.StatusUnauthorized, "bad or missing token"))
+		return
+	}
+	p := strings.TrimPrefix(r.URL.Path, "/v1/")
+	if p == r.URL.Path {
+		writeError(w, errorf(http.StatusNotFound, "unknown API version"))
+		return
+	}
+	parts := strings.Split(strings.TrimSuffix(p, "/"), "/")
+	var err error
+	switch {
+	case p == "events" && r.Method == "GET":
+		h.serveEvents(w, r)
+		return
+	case p == "settings":
+		err = h.serveSettings(w, r)
+	case parts[0] == "torrents" && len(parts) == 1:
+		err = h.serveTorrents(w, r)
+	case parts[0] == "torrents":
+		err = h.serveTorrent(w, r, parts[1], parts[2:])
+	default:
+		err = errorf(http.StatusNotFound, "not found")
+	}
+	if err != nil {
+		writeError(w, err)
+	}
+}
+
+func methodNotAllowed(r *http.Request) error {
+	return errorf(http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+}
+
+func (h *Handler) serveTorrents(w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case "GET":
+		ts := []Torrent{}
+		for _, t := range h.Client.Torrents() {
+			ts = append(ts, torrentJSON(t))
+		}
+		writeJSON(w, http.StatusOK, ts)
+		return nil
+	case "POST":
+		t, new, err := h.addTorrent(r)
+		if err != nil {
+			return err
+		}
+		// A torrent that was already added keeps the file priorities it has.
+		code := http.StatusOK
+		if new {
+			code = http.StatusCreated
+			downloadAll(t)
+		}
+		writeJSON(w, code, torrentJSON(t))
+		return nil
+	default:
+		return methodNotAllowed(r)
+	}
+}
+
+// Downloads all of the torrent once its info is available.
+func downloadAll(t *torrent.Torrent) {
+	if t.Info() != nil {
+		t.DownloadAll()
+		return
+	}
+	go func() {
+		<-t.GotInfo()
+		t.DownloadAll()
+	}()
+}
+
+// Adds the torrent in the request. new is false if the Client already had
+// it.
+func (h *Handler) addTorrent(r *http.Request) (t *torrent.Torrent, new bool, err error) {
+	var mi *metainfo.MetaInfo
+	if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct == "application/x-bittorrent" {
+		mi, err = metainfo.Load(r.Body)
+	} else {
+		var req AddRequest
+		if err = readJSON(r, &req); err != nil {
+			return
+		}
+		switch {
+		case req.Magnet != "":
+			var spec *torrent.TorrentSpec
+			spec, err = torrent.TorrentSpecFromMagnetURI(req.Magnet)
+			if err == nil {
+				t, new, err = h.Client.AddTorrentSpec(spec)
+			}
+			if err != nil {
+				err = errorf(http.StatusBadRequest, "error adding magnet: %s", err)
+			}
+			return
+		case req.URL != "":
+			mi, err = fetchMetainfo(req.URL)
+		case req.Metainfo != nil:
+			mi, err = metainfo.Load(bytes.NewReader(req.Metainfo))
+		default:
+			err = errorf(http.StatusBadRequest, "nothing to add")
+			return
+		}
+	}
+	if err != nil {
+		err = errorf(http.StatusBadRequest, "error loading metainfo: %s", err)
+		return
+	}
+	t, new, err = h.Client.AddTorrentSpec(torrent.TorrentSpecFromMetaInfo(mi))
+	if err != nil {
+		return
+	}
+	var nodes []string
+	for _, n := range mi.Nodes {
+		nodes = append(nodes, string(n))
+	}
+	h.Client.AddDHTNodes(nodes)
+	return
+}
+
+func fetchMetainfo(url string) (*metainfo.MetaInfo, error) {
+	resp, err := fetchClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("response status %q", resp.Status)
+	}
+	return metainfo.Load(resp.Body)
+}
+
+func (h *Handler) torrent(hexHash string) (*torrent.Torrent, error) {
+	var ih metainfo.Hash
+	if err := ih.FromHexString(hexHash); err != nil {
+		return nil, errorf(http.StatusBadRequest, "bad infohash: %s", err)
+	}
+	t, ok := h.Client.Torrent(ih)
+	if !ok {
+		return nil, errorf(http.StatusNotFound, "no such torrent")
+	}
+	return t, nil
+}
+
+func (h *Handler) serveTorrent(w http.ResponseWriter, r *http.Request, hexHash string, rest []string) error {
+	t, err := h.torrent(hexHash)
+	if err != nil {
+		return err
+	}
+	switch {
+	case len(rest) == 0 && r.Method == "GET":
+		tj := torrentJSON(t)
+		tj.Files = filesJSON(t)
+		writeJSON(w, http.StatusOK, tj)
+	case len(rest) == 0 && r.Method == "DELETE":
+		t.Drop()
+		w.WriteHeader(http.StatusNoContent)
+	case len(rest) == 1 && rest[0] == "pause" && r.Method == "POST":
+		t.Pause()
+		w.WriteHeader(http.StatusNoContent)
+	case len(rest) == 1 && rest[0] == "resume" && r.Method == "POST":
+		t.Resume()
+		w.WriteHeader(http.StatusNoContent)
+	case len(rest) == 1 && rest[0] == "queue" && r.Method == "PUT":
+		var qp QueuePosition
+		if err := readJSON(r, &qp); err != nil {
+			return err
+		}
+		t.SetQueuePosition(qp.Position)
+		w.WriteHeader(http.StatusNoContent)
+	case len(rest) == 1 && rest[0] == "peers" && r.Method == "GET":
+		writeJSON(w, http.StatusOK, peersJSON(t))
+	case len(rest) == 1 && rest[0] == "trackers" && r.Method == "GET":
+		al := t.Metainfo().AnnounceList
+		if al == nil {
+			al = [][]string{}
+		}
+		writeJSON(w, http.StatusOK, al)
+	case len(rest) == 2 && rest[0] == "files" && r.Method == "PUT":
+		return setFilePriority(w, r, t, rest[1])
+	case len(rest) == 0, len(rest) == 1 && (rest[0] == "pause" || rest[0] == "resume" || rest[0] == "queue" || rest[0] == "peers" || rest[0] == "trackers"), len(rest) == 2 && rest[0] == "files":
+		return methodNotAllowed(r)
+	default:
+		return errorf(http.StatusNotFound, "not found")
+	}
+	return nil
+}
+
+func setFilePriority(w http.ResponseWriter, r *http.Request, t *torrent.Torrent, index string) error {
+	files := t.Files()
+	i, err := strconv.Atoi(index)
+	if err != nil || i < 0 || i >= len(files) {
+		return errorf(http.StatusNotFound, "no such file")
+	}
+	var fp FilePriority
+	if err := readJSON(r, &fp); err != nil {
+		return err
+	}
+	prio, ok := priorities[fp.Priority]
+	if !ok {
+		return errorf(http.StatusBadRequest, "unknown priority %q", fp.Priority)
+	}
+	files[i].SetPriority(prio)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (h *Handler) settings() Settings {
+	l := h.Client.ConnLimits()
+	qc := h.Client.QueueConfig()
+	s := Settings{
+		PeerID:             hex.EncodeToString([]byte(h.Client.PeerID())),
+		MaxConns:           l.MaxConns,
+		MaxHalfOpen:        l.MaxHalfOpen,
+		MaxPendingAccepts:  l.MaxPendingAccepts,
+		MaxActiveDownloads: qc.MaxActiveDownloads,
+		MaxActiveSeeds:     qc.MaxActiveSeeds,
+		StallTimeout:       int64(qc.StallTimeout / time.Second),
+		SeedRatio:          qc.SeedRatio,
+		SeedTime:           int64(qc.SeedTime / time.Second),
+	}
+	if addr := h.Client.ListenAddr(); addr != nil {
+		s.ListenAddr = addr.String()
+	}
+	return s
+}
+
+func (h *Handler) serveSettings(w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case "GET":
+	case "PUT":
+		// Fields that aren't given keep their current values.
+		s := h.settings()
+		if err := readJSON(r, &s); err != nil {
+			return err
+		}
+		h.Client.SetConnLimits(torrent.ConnLimits{
+			MaxConns:          s.MaxConns,
+			MaxHalfOpen:       s.MaxHalfOpen,
+			MaxPendingAccepts: s.MaxPendingAccepts,
+		})
+		h.Client.SetQueueConfig(torrent.QueueConfig{
+			MaxActiveDownloads: s.MaxActiveDownloads,
+			MaxActiveSeeds:     s.MaxActiveSeeds,
+			StallTimeout:       time.Duration(s.StallTimeout) * time.Second,
+			SeedRatio:          s.SeedRatio,
+			SeedTime:           time.Duration(s.SeedTime) * time.Second,
+		})
+	default:
+		return methodNotAllowed(r)
+	}
+	writeJSON(w, http.StatusOK, h.settings())
+	return nil
+}
+
+func torrentJSON(t *torrent.Torrent) Torrent {
+	ret := Torrent{
+		InfoHash:      t.InfoHash().HexString(),
+		Name:          t.Name(),
+		State:         t.State().String(),
+		Paused:        t.Paused(),
+		Queued:        t.Queued(),
+		QueuePosition: t.QueuePosition(),
+		Peers:         len(t.PeerConns()),
+	}
+	select {
+	case <-t.GotInfo():
+	default:
+		return ret
+	}
+	ret.HaveInfo = true
+	ret.Length = t.Length()
+	ret.BytesCompleted = t.BytesCompleted()
+	ret.BytesUploaded = t.BytesUploaded()
+	ret.BytesWasted = t.BytesWasted()
+	ret.Seeding = t.Seeding()
+	return ret
+}
+
+func filesJSON(t *torrent.Torrent) (ret []File) {
+	for _, f := range t.Files() {
+		fj := File{
+			Path:   f.DisplayPath(),
+			Offset: f.Offset(),
+			Length: f.Length(),
+		}
+		for _, ps := range f.State() {
+			if ps.Complete {
+				fj.BytesCompleted += ps.Bytes
+			}
+		}
+		ret = append(ret, fj)
+	}
+	return
+}
+
+func peersJSON(t *torrent.Torrent) []Peer {
+	ret := []Peer{}
+	for _, pc := range t.PeerConns() {
+		p := Peer{
+			PeerID:         hex.EncodeToString(pc.PeerID[:]),
+			ClientName:     pc.ClientName,
+			Source:         pc.Source.String(),
+			Encrypted:      pc.Encrypted,
+			UTP:            pc.UTP,
+			Connected:      pc.Connected,
+			Interested:     pc.Interested,
+			Choked:         pc.Choked,
+			PeerInterested: pc.PeerInterested,
+			PeerChoked:     pc.PeerChoked,
+			Snubbed:        pc.Snubbed,
+			DownloadRate:   pc.DownloadRate,
+			UploadRate:     pc.UploadRate,
+		}
+		if pc.RemoteAddr != nil {
+			p.Addr = pc.RemoteAddr.String()
+		}
+		ret = append(ret, p)
+	}
+	return ret
+}
@@ -0,0 +1,186 @@
+package httpapi
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/internal/testutil"
+)
+
+func TestAPI(t *testing.T) {
+	dir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(dir)
+	cl, err := torrent.NewClient(&torrent.Config{
+		DataDir:         dir,
+		NoDHT:           true,
+		DisableTrackers: true,
+		ListenAddr:      "localhost:0",
+	})
+	require.NoError(t, err)
+	defer cl.Close()
+	s := httptest.NewServer(&Handler{Client: cl})
+	defer s.Close()
+	c := Client{URL: s.URL}
+
+	var buf bytes.Buffer
+	require.NoError(t, mi.Write(&buf))
+	added, err := c.AddMetainfo(&buf)
+	require.NoError(t, err)
+	ih := mi.Info.Hash().HexString()
+	assert.Equal(t, ih, added.InfoHash)
+	ts, err := c.Torrents()
+	require.NoError(t, err)
+	require.Len(t, ts, 1)
+	assert.Equal(t, "greeting", ts[0].Name)
+
+	tj, err := c.Torrent(ih)
+	require.NoError(t, err)
+	assert.True(t, tj.HaveInfo)
+	assert.EqualValues(t, len(testutil.GreetingFileContents), tj.Length)
+	require.Len(t, tj.Files, 1)
+	assert.Equal(t, "greeting", tj.Files[0].Path)
+
+	require.NoError(t, c.Pause(ih))
+	tj, err = c.Torrent(ih)
+	require.NoError(t, err)
+	assert.True(t, tj.Paused)
+	assert.Equal(t, "stopped", tj.State)
+	require.NoError(t, c.Resume(ih))
+	assert.False(t, cl.Torrents()[0].Paused())
+	require.NoError(t, c.SetQueuePosition(ih, 1))
+	assert.Equal(t, 0, cl.Torrents()[0].QueuePosition())
+
+	peers, err := c.Peers(ih)
+	require.NoError(t, err)
+	assert.Empty(t, peers)
+	_, err = c.Trackers(ih)
+	require.NoError(t, err)
+	require.NoError(t, c.SetFilePriority(ih, 0, "high"))
+	assert.EqualError(t, c.SetFilePriority(ih, 0, "urgent"), `unknown priority "urgent"`)
+	assert.EqualError(t, c.SetFilePriority(ih, 1, "high"), "no such file")
+
+	settings, err := c.SetSettings(map[string]interface{}{"max_conns": 10})
+	require.NoError(t, err)
+	assert.Equal(t, 10, settings.MaxConns)
+	assert.Equal(t, 10, cl.ConnLimits().MaxConns)
+	settings, err = c.SetSettings(map[string]interface{}{"max_active_downloads": 2, "seed_time": 60})
+	require.NoError(t, err)
+	assert.Equal(t, 10, settings.MaxConns)
+	assert.Equal(t, torrent.QueueConfig{MaxActiveDownloads: 2, SeedTime: time.Minute}, cl.QueueConfig())
+
+	var events []string
+	done := errors.New("done")
+	err = c.Events(func(ev Event) error {
+		assert.Equal(t, ih, ev.InfoHash)
+		events = append(events, ev.Type)
+		switch ev.Type {
+		case EventAdded:
+			require.NoError(t, c.Drop(ih))
+		case EventRemoved:
+			return done
+		}
+		return nil
+	})
+	assert.Equal(t, done, err)
+	assert.Equal(t, EventAdded, events[0])
+	assert.Equal(t, EventRemoved, events[len(events)-1])
+	_, err = c.Torrent(ih)
+	assert.EqualError(t, err, "no such torrent")
+}
+
+func TestEventsPieces(t *testing.T) {
+	dir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(dir)
+	cl, err := torrent.NewClient(&torrent.Config{
+		DataDir:         dir,
+		NoDHT:           true,
+		DisableTrackers: true,
+		ListenAddr:      "localhost:0",
+	})
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, err := cl.AddTorrent(mi)
+	require.NoError(t, err)
+	s := httptest.NewServer(&Handler{Client: cl})
+	defer s.Close()
+	c := Client{URL: s.URL}
+	done := errors.New("done")
+	err = c.Events(func(ev Event) error {
+		switch ev.Type {
+		case EventAdded:
+			// The torrent is being watched by now.
+			go tt.VerifyData()
+		case EventPiece:
+			require.NotNil(t, ev.Piece)
+			assert.True(t, ev.Piece.Index >= 0 && ev.Piece.Index < tt.NumPieces())
+			return done
+		}
+		return nil
+	})
+	assert.Equal(t, done, err)
+}
+
+func TestToken(t *testing.T) {
+	cl, err := torrent.NewClient(&torrent.Config{
+		DataDir:         "/dev/null",
+		NoDHT:           true,
+		DisableTrackers: true,
+		ListenAddr:      "localhost:0",
+	})
+	require.NoError(t, err)
+	defer cl.Close()
+	s := httptest.NewServer(&Handler{Client: cl, Token: "secret"})
+	defer s.Close()
+	c := Client{URL: s.URL}
+	_, err = c.Torrents()
+	assert.EqualError(t, err, "bad or missing token")
+	err = c.Events(func(Event) error { return nil })
+	assert.Error(t, err)
+	c.Token = "wrong"
+	_, err = c.Torrents()
+	assert.Error(t, err)
+	c.Token = "secret"
+	ts, err := c.Torrents()
+	require.NoError(t, err)
+	assert.Empty(t, ts)
+}
+
+func TestReaddKeepsFilePriorities(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	cl, err := torrent.NewClient(&torrent.Config{
+		DataDir:         dir,
+		NoDHT:           true,
+		DisableTrackers: true,
+		ListenAddr:      "localhost:0",
+	})
+	require.NoError(t, err)
+	defer cl.Close()
+	s := httptest.NewServer(&Handler{Client: cl})
+	defer s.Close()
+	c := Client{URL: s.URL}
+	mi := testutil.GreetingMetaInfo()
+	var buf bytes.Buffer
+	require.NoError(t, mi.Write(&buf))
+	b := buf.Bytes()
+	_, err = c.AddMetainfo(bytes.NewReader(b))
+	require.NoError(t, err)
+	tt := cl.Torrents()[0]
+	assert.Equal(t, torrent.PiecePriorityNormal, tt.PieceState(0).Priority)
+	ih := mi.Info.Hash().HexString()
+	require.NoError(t, c.SetFilePriority(ih, 0, "skip"))
+	assert.Equal(t, torrent.PiecePriorityNone, tt.PieceState(0).Priority)
+	_, err = c.AddMetainfo(bytes.NewReader(b))
+	require.NoError(t, err)
+	assert.Equal(t, torrent.PiecePriorityNone, tt.PieceState(0).Priority)
+}
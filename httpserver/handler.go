@@ -0,0 +1,218 @@
+// Package httpserver serves the files of torrents over HTTP, streaming their
+// data from the swarm as it's read.
+package httpserver
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+const defaultInfoTimeout = time.Minute
+
+// Serves the files of a Client's torrents. The paths are:
+//
+//	/                          an index of the torrents
+//	/<infohash>/               an index of the torrent's files
+//	/<infohash>.m3u            a playlist of the torrent's files
+//	/<infohash>/<file path>    the file, with support for Range requests
+//
+// File paths are as given by File.DisplayPath. Links are relative, so the
+// Handler can be mounted under a prefix with http.StripPrefix.
+type Handler struct {
+	Client *torrent.Client
+	// Add torrents that aren't in the Client when they're first requested.
+	// The query may have "dn" and "tr" values, as for a magnet link.
+	AddTorrents bool
+	// How long a request waits for a torrent's info. Defaults to a minute.
+	InfoTimeout time.Duration
+	// The Readahead for the Reader of each request for a file. Zero leaves
+	// the Reader's default.
+	Readahead int64
+}
+
+func (h *Handler) infoTimeout() time.Duration {
+	if h.InfoTimeout > 0 {
+		return h.InfoTimeout
+	}
+	return defaultInfoTimeout
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p := strings.TrimPrefix(r.URL.Path, "/")
+	if p == "" {
+		h.serveIndex(w, r)
+		return
+	}
+	i := strings.IndexByte(p, '/')
+	if i < 0 {
+		if strings.HasSuffix(p, ".m3u") {
+			t := h.torrent(w, r, strings.TrimSuffix(p, ".m3u"))
+			if t != nil {
+				servePlaylist(w, t)
+			}
+			return
+		}
+		http.Redirect(w, r, path.Base(p)+"/", http.StatusMovedPermanently)
+		return
+	}
+	t := h.torrent(w, r, p[:i])
+	if t == nil {
+		return
+	}
+	filePath := p[i+1:]
+	if filePath == "" {
+		serveTorrentIndex(w, t)
+		return
+	}
+	for i, f := range t.Files() {
+		if f.DisplayPath() == filePath {
+			h.serveFile(w, r, t, i, f)
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+// Returns the torrent, with its info, for the hex infohash. If it returns
+// nil, an error response has been written.
+func (h *Handler) torrent(w http.ResponseWriter, r *http.Request, hexHash string) *torrent.Torrent {
+	var ih metainfo.Hash
+	if err := ih.FromHexString(hexHash); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+	t, ok := h.Client.Torrent(ih)
+	if !ok {
+		if !h.AddTorrents {
+			http.NotFound(w, r)
+			return nil
+		}
+		t, _ = h.Client.AddTorrentInfoHash(ih)
+		q := r.URL.Query()
+		if dn := q.Get("dn"); dn != "" {
+			t.SetDisplayName(dn)
+		}
+		if tr := q["tr"]; len(tr) != 0 {
+			t.AddTrackers([][]string{tr})
+		}
+	}
+	var closed <-chan bool
+	if cn, ok := w.(http.CloseNotifier); ok {
+		closed = cn.CloseNotify()
+	}
+	timeout := time.NewTimer(h.infoTimeout())
+	defer timeout.Stop()
+	select {
+	case <-t.GotInfo():
+		return t
+	case <-closed:
+	case <-timeout.C:
+		http.Error(w, "timed out waiting for torrent info", http.StatusGatewayTimeout)
+	}
+	return nil
+}
+
+func (h *Handler) serveFile(w http.ResponseWriter, r *http.Request, t *torrent.Torrent, index int, f torrent.File) {
+	tr := f.NewReader()
+	defer tr.Close()
+	tr.SetResponsive()
+	if h.Readahead != 0 {
+		tr.SetReadahead(h.Readahead)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if cn, ok := w.(http.CloseNotifier); ok {
+		closed := cn.CloseNotify()
+		go func() {
+			select {
+			case <-closed:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+	// The data for an infohash never changes.
+	w.Header().Set("ETag", fmt.Sprintf(`"%s-%d"`, t.InfoHash().HexString(), index))
+	http.ServeContent(w, r, path.Base(f.DisplayPath()), time.Time{}, contextReader{tr, ctx})
+}
+
+// Abandons reads when ctx is done.
+type contextReader struct {
+	*torrent.Reader
+	ctx context.Context
+}
+
+func (me contextReader) Read(b []byte) (int, error) {
+	return me.ReadContext(b, me.ctx)
+}
+
+// Escapes each element of a slash-separated path.
+func escapePath(p string) string {
+	ss := strings.Split(p, "/")
+	for i, s := range ss {
+		ss[i] = url.QueryEscape(s)
+		ss[i] = strings.Replace(ss[i], "+", "%20", -1)
+	}
+	return strings.Join(ss, "/")
+}
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<title>Torrents</title>
+<ul>
+{{range .}}<li><a href="{{.InfoHash.HexString}}/">{{.Name}}</a> (<a href="{{.InfoHash.HexString}}.m3u">playlist</a>)
+{{end}}</ul>
+`))
+
+func (h *Handler) serveIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	indexTemplate.Execute(w, h.Client.Torrents())
+}
+
+type torrentIndexFile struct {
+	Path   string
+	Href   string
+	Length int64
+}
+
+var torrentIndexTemplate = template.Must(template.New("torrentIndex").Parse(`<!DOCTYPE html>
+<title>{{.Name}}</title>
+<h1>{{.Name}}</h1>
+<ul>
+{{range .Files}}<li><a href="{{.Href}}">{{.Path}}</a> ({{.Length}} bytes)
+{{end}}</ul>
+`))
+
+func serveTorrentIndex(w http.ResponseWriter, t *torrent.Torrent) {
+	var files []torrentIndexFile
+	for _, f := range t.Files() {
+		files = append(files, torrentIndexFile{
+			Path:   f.DisplayPath(),
+			Href:   escapePath(f.DisplayPath()),
+			Length: f.Length(),
+		})
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	torrentIndexTemplate.Execute(w, struct {
+		Name  string
+		Files []torrentIndexFile
+	}{t.Name(), files})
+}
+
+func servePlaylist(w http.ResponseWriter, t *torrent.Torrent) {
+	w.Header().Set("Content-Type", "audio/x-mpegurl")
+	fmt.Fprintln(w, "#EXTM3U")
+	for _, f := range t.Files() {
+		fmt.Fprintf(w, "#EXTINF:-1,%s\n", f.DisplayPath())
+		fmt.Fprintf(w, "%s/%s\n", t.InfoHash().HexString(), escapePath(f.DisplayPath()))
+	}
+}
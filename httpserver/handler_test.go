@@ -0,0 +1,72 @@
+package httpserver
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/internal/testutil"
+)
+
+func get(t *testing.T, url string, header http.Header) (*http.Response, string) {
+	req, err := http.NewRequest("GET", url, nil)
+	require.NoError(t, err)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(b)
+}
+
+func TestServeGreeting(t *testing.T) {
+	dir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(dir)
+	cl, err := torrent.NewClient(&torrent.Config{
+		DataDir:         dir,
+		NoDHT:           true,
+		DisableTrackers: true,
+		ListenAddr:      "localhost:0",
+	})
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, err := cl.AddTorrent(mi)
+	require.NoError(t, err)
+	s := httptest.NewServer(&Handler{Client: cl})
+	defer s.Close()
+	ih := tt.InfoHash().HexString()
+
+	resp, body := get(t, s.URL+"/"+ih+"/greeting", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, testutil.GreetingFileContents, body)
+	etag := resp.Header.Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	resp, body = get(t, s.URL+"/"+ih+"/greeting", http.Header{"Range": {"bytes=7-"}})
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	assert.Equal(t, "world\n", body)
+
+	resp, _ = get(t, s.URL+"/"+ih+"/greeting", http.Header{"If-None-Match": {etag}})
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+	resp, _ = get(t, s.URL+"/"+ih+"/nope", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	_, body = get(t, s.URL+"/", nil)
+	assert.Contains(t, body, `href="`+ih+`/"`)
+
+	_, body = get(t, s.URL+"/"+ih+".m3u", nil)
+	assert.Equal(t, "#EXTM3U\n#EXTINF:-1,greeting\n"+ih+"/greeting\n", body)
+
+	resp, _ = get(t, s.URL+"/"+"0123456789012345678901234567890123456789/", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
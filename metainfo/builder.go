@@ -0,0 +1,324 @@
+package metainfo
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// What to do with symlinks found while building an Info.
+type SymlinkPolicy int
+
+const (
+	// Include the data the symlink points to. Symlinks to directories are
+	// descended, unless the directory is already being walked.
+	SymlinksFollow SymlinkPolicy = iota
+	// Leave symlinks out of the torrent.
+	SymlinksSkip
+	// Store symlinks as BEP 47 symlink entries with no data.
+	SymlinksKeep
+)
+
+// Builds Infos from files on disk. The zero value uses defaults suitable for
+// most torrents.
+type Builder struct {
+	// If zero, a piece length is chosen with ChoosePieceLength.
+	PieceLength int64
+	// The number of pieces hashed concurrently. Defaults to the number of
+	// CPUs.
+	HashWorkers int
+	// If not nil, called with the number of pieces hashed so far and the
+	// total, after each piece is hashed. Calls are not concurrent.
+	Progress func(hashed, total int)
+	// If not nil, files and directories are only included when this returns
+	// true. path is relative to the root, and the root itself isn't
+	// filtered.
+	Filter func(path string, fi os.FileInfo) bool
+	// Defaults to SymlinksFollow.
+	Symlinks SymlinkPolicy
+	// Insert BEP 47 pad files so that each file begins on a piece boundary.
+	PadFiles bool
+	// Tell peers to only use the trackers in the metainfo.
+	Private bool
+}
+
+// Returns a piece length giving roughly 1500 pieces, rounded up to a power of
+// two between 16KiB and 16MiB.
+func ChoosePieceLength(totalLength int64) int64 {
+	const (
+		min = 16 << 10
+		max = 16 << 20
+	)
+	pl := int64(min)
+	for pl < max && totalLength/pl > 1500 {
+		pl *= 2
+	}
+	return pl
+}
+
+// A file to be included in the torrent, and where its data is.
+type builderFile struct {
+	FileInfo
+	// Empty for files without data from disk, such as padding.
+	osPath string
+}
+
+// Walks root and hashes its files into a new Info.
+func (b *Builder) Build(root string) (info *Info, err error) {
+	fi, err := os.Stat(root)
+	if err != nil {
+		return
+	}
+	info = &Info{
+		Name: filepath.Base(root),
+	}
+	if b.Private {
+		private := true
+		info.Private = &private
+	}
+	var files []builderFile
+	if fi.IsDir() {
+		files, err = b.walk(root, nil, map[string]bool{})
+		if err != nil {
+			return
+		}
+	} else {
+		files = []builderFile{{
+			FileInfo: FileInfo{Length: fi.Size(), Attr: fileModeAttrs(fi)},
+			osPath:   root,
+		}}
+	}
+	var total int64
+	for _, f := range files {
+		total += f.Length
+	}
+	info.PieceLength = b.PieceLength
+	if info.PieceLength == 0 {
+		info.PieceLength = ChoosePieceLength(total)
+	}
+	if b.PadFiles && fi.IsDir() {
+		files = padFiles(files, info.PieceLength)
+	}
+	if fi.IsDir() {
+		for _, f := range files {
+			info.Files = append(info.Files, f.FileInfo)
+		}
+		if len(info.Files) == 0 {
+			err = errors.New("no files to include")
+			return
+		}
+	} else {
+		info.Length = fi.Size()
+	}
+	info.Pieces, err = b.hashPieces(files, info.PieceLength)
+	return
+}
+
+func (b *Builder) walk(dir string, dirPath []string, walking map[string]bool) (ret []builderFile, err error) {
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return
+	}
+	if walking[realDir] {
+		return
+	}
+	walking[realDir] = true
+	defer delete(walking, realDir)
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, fi := range fis {
+		osPath := filepath.Join(dir, fi.Name())
+		path := append(append([]string(nil), dirPath...), fi.Name())
+		if b.Filter != nil && !b.Filter(filepath.Join(path...), fi) {
+			continue
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			switch b.Symlinks {
+			case SymlinksSkip:
+				continue
+			case SymlinksKeep:
+				var target string
+				target, err = os.Readlink(osPath)
+				if err != nil {
+					return
+				}
+				ret = append(ret, builderFile{FileInfo: FileInfo{
+					Path:        path,
+					Attr:        "l",
+					SymlinkPath: strings.Split(filepath.ToSlash(target), "/"),
+				}})
+				continue
+			}
+			fi, err = os.Stat(osPath)
+			if err != nil {
+				return
+			}
+		}
+		if fi.IsDir() {
+			var sub []builderFile
+			sub, err = b.walk(osPath, path, walking)
+			if err != nil {
+				return
+			}
+			ret = append(ret, sub...)
+			continue
+		}
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		ret = append(ret, builderFile{
+			FileInfo: FileInfo{
+				Length: fi.Size(),
+				Path:   path,
+				Attr:   fileModeAttrs(fi),
+			},
+			osPath: osPath,
+		})
+	}
+	return
+}
+
+// Returns the BEP 47 attributes that apply to a regular file.
+func fileModeAttrs(fi os.FileInfo) (attrs string) {
+	if fi.Mode()&0111 != 0 {
+		attrs += "x"
+	}
+	if fi.Name()[0] == '.' {
+		attrs += "h"
+	}
+	return
+}
+
+// Inserts pad files so that every file after the first starts on a piece
+// boundary.
+func padFiles(files []builderFile, pieceLength int64) (ret []builderFile) {
+	var off int64
+	for i, f := range files {
+		if rem := off % pieceLength; rem != 0 && i != 0 && f.Length != 0 {
+			padLen := pieceLength - rem
+			ret = append(ret, builderFile{FileInfo: FileInfo{
+				Length: padLen,
+				Path:   []string{".pad", strconv.FormatInt(padLen, 10)},
+				Attr:   "p",
+			}})
+			off += padLen
+		}
+		ret = append(ret, f)
+		off += f.Length
+	}
+	return
+}
+
+// Hashes the pieces spanning files, with b.HashWorkers goroutines.
+func (b *Builder) hashPieces(files []builderFile, pieceLength int64) ([]byte, error) {
+	var total int64
+	for _, f := range files {
+		total += f.Length
+	}
+	numPieces := int((total + pieceLength - 1) / pieceLength)
+	pieces := make([]byte, numPieces*sha1.Size)
+	workers := b.HashWorkers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	var (
+		mu       sync.Mutex
+		next     int
+		hashed   int
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, pieceLength)
+			for {
+				mu.Lock()
+				i := next
+				next++
+				stop := i >= numPieces || firstErr != nil
+				mu.Unlock()
+				if stop {
+					return
+				}
+				off := int64(i) * pieceLength
+				n := pieceLength
+				if off+n > total {
+					n = total - off
+				}
+				err := readFilesAt(files, buf[:n], off)
+				var sum [sha1.Size]byte
+				if err == nil {
+					sum = sha1.Sum(buf[:n])
+				}
+				mu.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = err
+					}
+				} else {
+					copy(pieces[i*sha1.Size:], sum[:])
+					hashed++
+					if b.Progress != nil {
+						b.Progress(hashed, numPieces)
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return pieces, firstErr
+}
+
+// Fills b with the data at off in the concatenation of files. Pad files read
+// as zeroes.
+func readFilesAt(files []builderFile, b []byte, off int64) error {
+	for _, f := range files {
+		if len(b) == 0 {
+			break
+		}
+		if off >= f.Length {
+			off -= f.Length
+			continue
+		}
+		n := int64(len(b))
+		if n > f.Length-off {
+			n = f.Length - off
+		}
+		if f.osPath == "" {
+			for i := range b[:n] {
+				b[i] = 0
+			}
+		} else if err := readFileAt(f.osPath, b[:n], off); err != nil {
+			return err
+		}
+		b = b[n:]
+		off = 0
+	}
+	return nil
+}
+
+func readFileAt(name string, b []byte, off int64) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.ReadAt(b, off)
+	if err == io.EOF {
+		err = fmt.Errorf("%s: file shrank while hashing", name)
+	}
+	return err
+}
@@ -0,0 +1,62 @@
+package metainfo
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuilderPadFiles(t *testing.T) {
+	td, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(td)
+	root := filepath.Join(td, "root")
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "b"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "a"), []byte("hello"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "b", "c"), []byte("world\n"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "ignored"), []byte("nope"), 0644))
+	var progress []int
+	b := Builder{
+		PieceLength: 4,
+		HashWorkers: 3,
+		PadFiles:    true,
+		Progress: func(hashed, total int) {
+			progress = append(progress, hashed)
+			assert.Equal(t, 4, total)
+		},
+		Filter: func(path string, fi os.FileInfo) bool {
+			return path != "ignored"
+		},
+	}
+	info, err := b.Build(root)
+	require.NoError(t, err)
+	assert.Equal(t, "root", info.Name)
+	assert.EqualValues(t, []int{1, 2, 3, 4}, progress)
+	require.Len(t, info.Files, 3)
+	assert.Equal(t, []string{"a"}, info.Files[0].Path)
+	assert.True(t, info.Files[1].IsPadding())
+	assert.EqualValues(t, 3, info.Files[1].Length)
+	assert.Equal(t, []string{"b", "c"}, info.Files[2].Path)
+	// The pieces should match those hashed sequentially with the padding as
+	// zeroes.
+	expected := Info{PieceLength: 4, Files: info.Files}
+	require.NoError(t, expected.GeneratePieces(func(fi FileInfo) (io.ReadCloser, error) {
+		if fi.IsPadding() {
+			return ioutil.NopCloser(strings.NewReader(strings.Repeat("\x00", int(fi.Length)))), nil
+		}
+		return os.Open(filepath.Join(append([]string{root}, fi.Path...)...))
+	}))
+	assert.Equal(t, expected.Pieces, info.Pieces)
+}
+
+func TestChoosePieceLength(t *testing.T) {
+	assert.EqualValues(t, 16<<10, ChoosePieceLength(0))
+	assert.EqualValues(t, 1<<20, ChoosePieceLength(1<<30))
+	assert.EqualValues(t, 16<<20, ChoosePieceLength(1<<40))
+}
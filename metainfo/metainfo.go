@@ -5,9 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"os"
-	"path/filepath"
 	"strings"
 	"time"
 
@@ -18,6 +16,16 @@ import (
 type FileInfo struct {
 	Length int64    `bencode:"length"`
 	Path   []string `bencode:"path"`
+	// BEP 47 attributes: "p" for padding, "x" for executable, "h" for hidden
+	// and "l" for symlink.
+	Attr        string   `bencode:"attr,omitempty"`
+	SymlinkPath []string `bencode:"symlink path,omitempty"`
+}
+
+// Returns true if the file is BEP 47 padding, which contains only zeroes
+// and shouldn't be stored.
+func (fi *FileInfo) IsPadding() bool {
+	return strings.ContainsRune(fi.Attr, 'p')
 }
 
 // Load a MetaInfo from an io.Reader. Returns a non-nil error in case of
@@ -52,43 +60,18 @@ type Info struct {
 	Files       []FileInfo `bencode:"files,omitempty"`
 }
 
-// This is a helper that sets Files and Pieces from a root path and its
-// children.
+// This is a helper that sets Name, Files and Pieces from a root path and its
+// children, using the existing PieceLength. See Builder for more control.
 func (info *Info) BuildFromFilePath(root string) (err error) {
-	info.Name = filepath.Base(root)
-	info.Files = nil
-	err = filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if fi.IsDir() {
-			// Directories are implicit in torrent files.
-			return nil
-		} else if path == root {
-			// The root is a file.
-			info.Length = fi.Size()
-			return nil
-		}
-		relPath, err := filepath.Rel(root, path)
-		log.Println(relPath, err)
-		if err != nil {
-			return fmt.Errorf("error getting relative path: %s", err)
-		}
-		info.Files = append(info.Files, FileInfo{
-			Path:   strings.Split(relPath, string(filepath.Separator)),
-			Length: fi.Size(),
-		})
-		return nil
-	})
-	if err != nil {
-		return
+	b := Builder{
+		PieceLength: info.PieceLength,
 	}
-	err = info.GeneratePieces(func(fi FileInfo) (io.ReadCloser, error) {
-		return os.Open(filepath.Join(root, strings.Join(fi.Path, string(filepath.Separator))))
-	})
+	built, err := b.Build(root)
 	if err != nil {
-		err = fmt.Errorf("error generating pieces: %s", err)
+		return
 	}
+	built.Private = info.Private
+	*info = *built
 	return
 }
 
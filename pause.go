@@ -0,0 +1,109 @@
+package torrent
+
+import (
+	"time"
+)
+
+// Stops all activity for the torrent without dropping it. Its connections
+// are closed, trackers are told it has stopped, and no peers are announced
+// for, connected to or accepted until Resume. Its storage, completion,
+// priorities and Readers are kept, though Readers don't progress.
+func (t *Torrent) Pause() {
+	t.cl.mu.Lock()
+	defer t.cl.mu.Unlock()
+	if t.paused || t.closed.IsSet() {
+		return
+	}
+	t.setStopped(true, t.queued)
+	t.cl.updateQueue(time.Now())
+	t.cl.event.Broadcast()
+}
+
+// Restarts a torrent stopped by Pause. If the Client is queueing torrents,
+// it waits its turn with the others.
+func (t *Torrent) Resume() {
+	t.cl.mu.Lock()
+	defer t.cl.mu.Unlock()
+	if !t.paused || t.closed.IsSet() {
+		return
+	}
+	t.setStopped(false, t.queued || t.cl.queueing())
+	t.cl.updateQueue(time.Now())
+	t.cl.event.Broadcast()
+}
+
+// Returns true if the torrent is stopped by Pause.
+func (t *Torrent) Paused() bool {
+	t.cl.mu.RLock()
+	defer t.cl.mu.RUnlock()
+	return t.paused
+}
+
+// Whether the torrent is paused, or waiting in the Client's queue.
+func (t *Torrent) stopped() bool {
+	return t.paused || t.queued
+}
+
+// Sets why the torrent is stopped, and stops or starts its activity if that
+// changes whether it's stopped at all.
+func (t *Torrent) setStopped(paused, queued bool) {
+	if paused == t.paused && queued == t.queued {
+		return
+	}
+	was := t.stopped()
+	t.paused, t.queued = paused, queued
+	switch {
+	case !was && t.stopped():
+		t.stopActivity()
+	case was && !t.stopped():
+		t.startActivity()
+	}
+	t.updateState()
+}
+
+func (t *Torrent) stopActivity() {
+	for _, c := range append([]*connection(nil), t.conns...) {
+		c.closeWithReason(ErrConnPaused)
+		t.dropConnection(c)
+	}
+	t.updateWantPeersEvent()
+	// Scrapers are started again with the torrent, which announces afresh.
+	req := t.announceRequest()
+	for url, ts := range t.trackerAnnouncers {
+		ts.stop.Set()
+		go ts.announceStopped(req)
+		delete(t.trackerAnnouncers, url)
+	}
+}
+
+func (t *Torrent) startActivity() {
+	// Time spent stopped doesn't count against it.
+	t.lastProgress = time.Now()
+	t.startMissingTrackerScrapers()
+	t.cl.openNewConns(t)
+}
+
+func (t *Torrent) state() TorrentState {
+	switch {
+	case t.paused:
+		return TorrentStopped
+	case t.queued && !t.needData() && t.seedLimitReached():
+		return TorrentFinished
+	case t.queued:
+		return TorrentQueued
+	case t.storageErr != nil:
+		return TorrentError
+	case !t.haveInfo():
+		return TorrentGettingInfo
+	case t.numCheckingPieces != 0:
+		return TorrentChecking
+	case t.seeding():
+		return TorrentSeeding
+	case !t.stalledSince.IsZero():
+		return TorrentStalled
+	case t.needData():
+		return TorrentDownloading
+	default:
+		return TorrentComplete
+	}
+}
@@ -0,0 +1,113 @@
+package torrent
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/anacrolix/missinggo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/torrent/internal/testutil"
+)
+
+func TestPauseClosesConns(t *testing.T) {
+	cl, err := NewClient(&TestingConfig)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt := testTorrentPieces(t, cl, 2)
+	cl.mu.Lock()
+	c := testAddConn(tt)
+	cl.mu.Unlock()
+	tt.Pause()
+	assert.True(t, tt.Paused())
+	cl.mu.Lock()
+	assert.Empty(t, tt.conns)
+	assert.Equal(t, ErrConnPaused, c.closeReason)
+	assert.False(t, tt.wantPeers())
+	assert.Empty(t, cl.receiveSkeys())
+	var buf bytes.Buffer
+	tt.writeStatus(&buf, cl)
+	assert.Contains(t, buf.String(), "State: stopped\n")
+	cl.mu.Unlock()
+	tt.Resume()
+	assert.False(t, tt.Paused())
+	cl.mu.Lock()
+	assert.Len(t, cl.receiveSkeys(), 1)
+	cl.mu.Unlock()
+}
+
+func TestPausedTorrentDoesntAnnounceAddedTrackers(t *testing.T) {
+	cfg := TestingConfig
+	cfg.DisableTrackers = false
+	cl, err := NewClient(&cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, _ := cl.AddTorrentInfoHash(testutil.GreetingMetaInfo().Info.Hash())
+	tt.Pause()
+	tt.AddTrackers([][]string{{"http://localhost:1/announce"}})
+	cl.mu.Lock()
+	assert.Empty(t, tt.trackerAnnouncers)
+	cl.mu.Unlock()
+	tt.Resume()
+	cl.mu.Lock()
+	assert.Len(t, tt.trackerAnnouncers, 1)
+	cl.mu.Unlock()
+}
+
+func TestResumeDroppedTorrent(t *testing.T) {
+	cfg := TestingConfig
+	cfg.DisableTrackers = false
+	cl, err := NewClient(&cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, _ := cl.AddTorrentInfoHash(testutil.GreetingMetaInfo().Info.Hash())
+	tt.AddTrackers([][]string{{"http://localhost:1/announce"}})
+	tt.Pause()
+	tt.Drop()
+	tt.Resume()
+	assert.True(t, tt.Paused())
+	cl.mu.Lock()
+	assert.Empty(t, tt.trackerAnnouncers)
+	cl.mu.Unlock()
+}
+
+func TestPauseResumeDownload(t *testing.T) {
+	greetingTempDir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(greetingTempDir)
+	cfg := TestingConfig
+	cfg.Seed = true
+	cfg.DataDir = greetingTempDir
+	seeder, err := NewClient(&cfg)
+	require.NoError(t, err)
+	defer seeder.Close()
+	seeder.AddTorrent(mi)
+	cfg.DataDir, err = ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(cfg.DataDir)
+	leecher, err := NewClient(&cfg)
+	require.NoError(t, err)
+	defer leecher.Close()
+	lt, err := leecher.AddTorrent(mi)
+	require.NoError(t, err)
+	lt.Pause()
+	lt.AddPeers([]Peer{{
+		IP:   missinggo.AddrIP(seeder.ListenAddr()),
+		Port: missinggo.AddrPort(seeder.ListenAddr()),
+	}})
+	leecher.mu.Lock()
+	assert.Empty(t, lt.halfOpen)
+	assert.Len(t, lt.peers, 1)
+	leecher.mu.Unlock()
+	lt.Resume()
+	r := lt.NewReader()
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.EqualValues(t, testutil.GreetingFileContents, b)
+	lt.Pause()
+	assert.Empty(t, lt.PeerConns())
+	assert.EqualValues(t, len(testutil.GreetingFileContents), lt.BytesCompleted())
+}
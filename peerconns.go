@@ -0,0 +1,110 @@
+package torrent
+
+import (
+	"time"
+)
+
+// The state of a connection to a peer at the time it was taken. See
+// Torrent.PeerConns.
+type PeerConn struct {
+	PeerInfo
+	LocalAddr string
+	// When handshakes completed.
+	Connected           time.Time
+	LastMessageReceived time.Time
+	LastUsefulChunk     time.Time
+	LastChunkSent       time.Time
+
+	// We're interested in the peer's pieces.
+	Interested bool
+	// We're choking the peer.
+	Choked bool
+	// The peer left a request unsatisfied for Config.RequestTimeout, and
+	// hasn't sent a chunk since.
+	Snubbed bool
+	// Requests we have outstanding with the peer.
+	Requests int
+
+	PeerInterested bool
+	PeerChoked     bool
+	// Requests the peer has outstanding with us.
+	PeerRequests int
+	// The most requests the peer will queue.
+	PeerMaxRequests int
+	// Indexed by piece, the pieces the peer has. It has the length of the
+	// torrent's pieces if the info is known, or otherwise of what the peer
+	// has told us about.
+	PeerPieces []bool
+
+	UsefulChunksReceived   int
+	UnwantedChunksReceived int
+	ChunksSent             int
+	// Pieces the peer sent chunks for that then passed or failed their
+	// hash.
+	GoodPiecesDirtied int
+	BadPiecesDirtied  int
+	// Estimated bytes per second of chunk data received from and sent to
+	// the peer.
+	DownloadRate float64
+	UploadRate   float64
+}
+
+func (cn *connection) snapshot(now time.Time) PeerConn {
+	ret := PeerConn{
+		PeerInfo:               cn.peerInfo(),
+		Connected:              cn.completedHandshake,
+		LastMessageReceived:    cn.lastMessageReceived,
+		LastUsefulChunk:        cn.lastUsefulChunkReceived,
+		LastChunkSent:          cn.lastChunkSent,
+		Interested:             cn.Interested,
+		Choked:                 cn.Choked,
+		Snubbed:                cn.snubbed,
+		Requests:               len(cn.Requests),
+		PeerInterested:         cn.PeerInterested,
+		PeerChoked:             cn.PeerChoked,
+		PeerRequests:           len(cn.PeerRequests),
+		PeerMaxRequests:        cn.PeerMaxRequests,
+		PeerPieces:             make([]bool, cn.bestPeerNumPieces()),
+		UsefulChunksReceived:   cn.UsefulChunksReceived,
+		UnwantedChunksReceived: cn.UnwantedChunksReceived,
+		ChunksSent:             cn.chunksSent,
+		GoodPiecesDirtied:      cn.goodPiecesDirtied,
+		BadPiecesDirtied:       cn.badPiecesDirtied,
+		DownloadRate:           cn.downloadRate.current(now),
+		UploadRate:             cn.uploadRate.current(now),
+	}
+	if cn.conn != nil {
+		ret.LocalAddr = cn.localAddr().String()
+	}
+	for i := range ret.PeerPieces {
+		ret.PeerPieces[i] = cn.PeerHasPiece(i)
+	}
+	return ret
+}
+
+// Returns the state of the torrent's peer connections.
+func (t *Torrent) PeerConns() (ret []PeerConn) {
+	t.cl.mu.RLock()
+	defer t.cl.mu.RUnlock()
+	now := time.Now()
+	for _, c := range t.conns {
+		ret = append(ret, c.snapshot(now))
+	}
+	return
+}
+
+// Closes the connection to the peer at the remote address, as given by
+// PeerConn.RemoteAddr. Returns false if there isn't one. The peer may be
+// connected to again later.
+func (t *Torrent) DropPeer(addr string) bool {
+	t.cl.mu.Lock()
+	defer t.cl.mu.Unlock()
+	for _, c := range t.conns {
+		if c.conn != nil && c.remoteAddr().String() == addr {
+			c.closeWithReason(ErrConnDropped)
+			t.dropConnection(c)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package torrent
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// A connection with a made up remote address.
+type addrConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (me addrConn) RemoteAddr() net.Addr { return me.remote }
+
+func TestPeerConns(t *testing.T) {
+	cl, err := NewClient(&TestingConfig)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt := testTorrentPieces(t, cl, 2)
+	cl.mu.Lock()
+	for i, port := range []int{1, 2} {
+		nc, _ := net.Pipe()
+		c := testAddConn(tt)
+		c.conn = addrConn{nc, &net.TCPAddr{IP: net.IPv4(1, 2, 3, 4), Port: port}}
+		c.PeerID[0] = byte(i)
+		c.Choked = true
+	}
+	tt.conns[1].peerPieces.Set(1, true)
+	tt.conns[1].UsefulChunksReceived = 3
+	cl.mu.Unlock()
+	pcs := tt.PeerConns()
+	require.Len(t, pcs, 2)
+	assert.Equal(t, "1.2.3.4:2", pcs[1].RemoteAddr.String())
+	assert.Equal(t, []bool{false, true}, pcs[1].PeerPieces)
+	assert.Equal(t, 3, pcs[1].UsefulChunksReceived)
+	assert.True(t, pcs[0].Choked)
+	assert.False(t, tt.DropPeer("1.2.3.4:3"))
+	assert.True(t, tt.DropPeer("1.2.3.4:1"))
+	pcs = tt.PeerConns()
+	require.Len(t, pcs, 1)
+	assert.Equal(t, "1.2.3.4:2", pcs[0].RemoteAddr.String())
+}
@@ -2,6 +2,7 @@ package torrent
 
 import (
 	"sync"
+	"time"
 
 	"github.com/anacrolix/missinggo/bitmap"
 
@@ -11,17 +12,19 @@ import (
 )
 
 // Piece priority describes the importance of obtaining a particular piece.
+// It's raised for pieces that Readers need, and is seen by PiecePickers in
+// PickerPiece.
+type PiecePriority byte
 
-type piecePriority byte
-
-func (pp *piecePriority) Raise(maybe piecePriority) {
+// Sets the priority to maybe, if that's higher.
+func (pp *PiecePriority) Raise(maybe PiecePriority) {
 	if maybe > *pp {
 		*pp = maybe
 	}
 }
 
 const (
-	PiecePriorityNone      piecePriority = iota // Not wanted.
+	PiecePriorityNone      PiecePriority = iota // Not wanted.
 	PiecePriorityNormal                         // Wanted.
 	PiecePriorityReadahead                      // May be required soon.
 	PiecePriorityNext                           // Succeeds a piece where a read occurred.
@@ -40,7 +43,30 @@ type piece struct {
 	QueuedForHash    bool
 	EverHashed       bool
 	PublicPieceState PieceState
-	priority         piecePriority
+	priority         PiecePriority
+	// The number of connected peers that have the piece.
+	availability int
+	// If not zero, when the piece is needed by.
+	deadline time.Time
+	// The earliest deadline given the piece by streaming Readers.
+	readerDeadline time.Time
+	// Set by the user, and kept by storage.
+	downloadPriority DownloadPriority
+	// The number of times the piece has been hashed.
+	numVerifies int64
+	// Queued by Torrent.queueCheck, and counted in numCheckingPieces.
+	checking bool
+	// The IP of the peer that supplied each dirty chunk.
+	chunkSuppliers map[int]string
+	// What we had of each chunk when the piece last failed, kept until it
+	// passes to determine who was responsible.
+	failedChunks map[int]blamedChunk
+	// If not nil, the piece is only requested from this connection.
+	redownloadFrom *connection
+	// Undirtied chunks that haven't been requested from any peer, and how
+	// many of them are counted in Torrent.numUnrequested.
+	numUnrequested     int
+	countedUnrequested int
 
 	pendingWritesMutex sync.Mutex
 	pendingWrites      int
@@ -72,10 +98,16 @@ func (p *piece) numDirtyChunks() (ret int) {
 }
 
 func (p *piece) unpendChunkIndex(i int) {
+	if !p.DirtyChunks.Contains(i) && !p.t.chunkIndexRequested(p.index, i) {
+		p.t.addUnrequested(p.index, -1)
+	}
 	p.DirtyChunks.Add(i)
 }
 
 func (p *piece) pendChunkIndex(i int) {
+	if p.DirtyChunks.Contains(i) && !p.t.chunkIndexRequested(p.index, i) {
+		p.t.addUnrequested(p.index, 1)
+	}
 	p.DirtyChunks.Remove(i)
 }
 
@@ -0,0 +1,137 @@
+package torrent
+
+import (
+	"time"
+)
+
+// Decides the order in which wanted pieces are requested. Each connection
+// requests the pieces its peer has in ascending order of the values returned
+// by PieceOrder. It's called with the Client lock held, whenever something
+// in PickerPiece may have changed, so it must not block or call back into
+// the Client.
+type PiecePicker interface {
+	PieceOrder(p PickerPiece) int
+}
+
+// What a PiecePicker knows about a wanted piece, when ordering it for a
+// connection.
+type PickerPiece struct {
+	Index     int
+	NumPieces int
+	// Raised for pieces that Readers are reading in or ahead into.
+	Priority PiecePriority
+	// Set by the user. See Torrent.SetPiecePriority.
+	DownloadPriority DownloadPriority
+	// The number of connected peers that have the piece, up to a small
+	// limit beyond which it's not tracked.
+	Availability int
+	// The piece's position in a random permutation of all the pieces that
+	// differs for each connection, for breaking ties.
+	Inclination int
+	// If not zero, when the piece is needed by. See
+	// Torrent.DownloadPiecesBy.
+	Deadline time.Time
+}
+
+// The built-in pickers request pieces with deadlines ahead of all others,
+// with those due soonest first. They're ordered at a resolution of a
+// second from this time.
+var deadlineEpoch = time.Now()
+
+// Orders a piece with a deadline ahead of any order the built-in pickers
+// give pieces without one, for torrents of up to a few million pieces.
+func deadlineOrder(deadline time.Time) int {
+	secs := deadline.Sub(deadlineEpoch) / time.Second
+	if secs < 0 {
+		secs = 0
+	}
+	if secs > 1<<29 {
+		secs = 1 << 29
+	}
+	return -1<<30 + int(secs)
+}
+
+// Requests the pieces that Readers are in or reading ahead into first, then
+// by download priority, and the rest by Selection. This is the default, with
+// Config.PieceSelection.
+type ReaderPiecePicker struct {
+	Selection PieceSelectionAlgorithm
+}
+
+func (me ReaderPiecePicker) PieceOrder(p PickerPiece) int {
+	if !p.Deadline.IsZero() {
+		return deadlineOrder(p.Deadline)
+	}
+	ret := p.Inclination + p.Index/2
+	// The span of orders within each group.
+	group := 2 * p.NumPieces
+	if me.Selection == PieceSelectionRarestFirst {
+		ret += 2 * p.NumPieces * p.Availability
+		group = 2 * p.NumPieces * (maxAvailabilityRank + 1)
+	}
+	class := 2
+	switch p.Priority {
+	case PiecePriorityReadahead:
+		class = 1
+	case PiecePriorityNext, PiecePriorityNow:
+		class = 0
+	}
+	return (class*numDownloadPriorityRanks+p.DownloadPriority.orderRank())*group + ret
+}
+
+// Requests the pieces the fewest connected peers have first within each
+// download priority, without regard for Readers.
+type RarestFirstPiecePicker struct{}
+
+func (RarestFirstPiecePicker) PieceOrder(p PickerPiece) int {
+	if !p.Deadline.IsZero() {
+		return deadlineOrder(p.Deadline)
+	}
+	group := (maxAvailabilityRank + 1) * p.NumPieces
+	return p.DownloadPriority.orderRank()*group + p.Availability*p.NumPieces + p.Inclination
+}
+
+// Requests pieces in the order they occur in the torrent within each
+// download priority, so that data can be processed as it arrives.
+type SequentialPiecePicker struct{}
+
+func (SequentialPiecePicker) PieceOrder(p PickerPiece) int {
+	if !p.Deadline.IsZero() {
+		return deadlineOrder(p.Deadline)
+	}
+	return p.DownloadPriority.orderRank()*p.NumPieces + p.Index
+}
+
+func (t *Torrent) piecePicker() PiecePicker {
+	if t.picker != nil {
+		return t.picker
+	}
+	if t.cl.config.PiecePicker != nil {
+		return t.cl.config.PiecePicker
+	}
+	return ReaderPiecePicker{t.cl.config.PieceSelection}
+}
+
+func (cn *connection) pickerPiece(piece int, prio PiecePriority) PickerPiece {
+	return PickerPiece{
+		Index:            piece,
+		NumPieces:        cn.t.numPieces(),
+		Priority:         prio,
+		DownloadPriority: cn.t.pieces[piece].downloadPriority,
+		Availability:     cn.t.pieceAvailabilityRank(piece),
+		Inclination:      cn.getPieceInclination()[piece],
+		Deadline:         cn.t.pieceDeadline(piece),
+	}
+}
+
+// Reorders every connection's requests.
+func (t *Torrent) updateRequestOrders() {
+	if !t.haveInfo() {
+		return
+	}
+	for _, c := range t.conns {
+		for i := range t.pieces {
+			c.updatePiecePriority(i)
+		}
+	}
+}
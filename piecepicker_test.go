@@ -0,0 +1,49 @@
+package torrent
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReaderPiecePickerClasses(t *testing.T) {
+	for _, sel := range []PieceSelectionAlgorithm{PieceSelectionRarestFirst, PieceSelectionRandom} {
+		pp := ReaderPiecePicker{sel}
+		now := pp.PieceOrder(PickerPiece{Index: 99, NumPieces: 100, Priority: PiecePriorityNow, Availability: maxAvailabilityRank, Inclination: 99})
+		readahead := pp.PieceOrder(PickerPiece{Index: 99, NumPieces: 100, Priority: PiecePriorityReadahead, Availability: maxAvailabilityRank, Inclination: 99})
+		normal := pp.PieceOrder(PickerPiece{Index: 0, NumPieces: 100, Priority: PiecePriorityNormal})
+		high := pp.PieceOrder(PickerPiece{Index: 99, NumPieces: 100, Priority: PiecePriorityNormal, DownloadPriority: DownloadPriorityHigh, Availability: maxAvailabilityRank, Inclination: 99})
+		low := pp.PieceOrder(PickerPiece{Index: 0, NumPieces: 100, Priority: PiecePriorityNormal, DownloadPriority: DownloadPriorityLow})
+		assert.True(t, now < readahead)
+		assert.True(t, readahead < high)
+		assert.True(t, high < normal)
+		assert.True(t, normal < low)
+		deadline := pp.PieceOrder(PickerPiece{Index: 99, NumPieces: 100, Priority: PiecePriorityNormal, Deadline: time.Now().Add(time.Hour)})
+		assert.True(t, deadline < now)
+	}
+}
+
+func TestSequentialPiecePicker(t *testing.T) {
+	cl, err := NewClient(&TestingConfig)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt := testTorrentPieces(t, cl, 4)
+	tt.SetPiecePicker(SequentialPiecePicker{})
+	cl.mu.Lock()
+	c := testAddConn(tt)
+	require.NoError(t, c.peerSentHaveAll())
+	assert.Equal(t, []int{0, 1, 2, 3}, requestOrder(c))
+	cl.mu.Unlock()
+	tt.DownloadPiecesBy(3, 4, time.Now().Add(time.Minute))
+	tt.DownloadPiecesBy(2, 3, time.Now())
+	cl.mu.Lock()
+	assert.Equal(t, []int{2, 3, 0, 1}, requestOrder(c))
+	cl.mu.Unlock()
+	tt.CancelPieces(2, 4)
+	tt.DownloadPieces(2, 4)
+	cl.mu.Lock()
+	assert.Equal(t, []int{0, 1, 2, 3}, requestOrder(c))
+	cl.mu.Unlock()
+}
@@ -2,7 +2,7 @@ package torrent
 
 // The current state of a piece.
 type PieceState struct {
-	Priority piecePriority
+	Priority PiecePriority
 	// The piece is available in its entirety.
 	Complete bool
 	// The piece is being hashed, or is queued for hash.
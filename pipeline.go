@@ -0,0 +1,176 @@
+package torrent
+
+import (
+	"time"
+)
+
+// Requests are pipelined so the peer always has something to send while
+// earlier requests are in flight. How many are needed depends on the link's
+// bandwidth-delay product, so the queue for each connection is sized from
+// the rate its chunks arrive at and the quickest a request has been
+// satisfied, with Config.RequestQueueTime worth of data on top. A peer that
+// leaves a request unsatisfied for Config.RequestTimeout is snubbed: its
+// requests are cancelled so that other peers can take them, and it's only
+// given one request at a time until it sends a chunk.
+
+const (
+	defaultRequestQueueTime = 3 * time.Second
+	defaultRequestTimeout   = time.Minute
+	// Used until the download rate is known.
+	initialRequestQueueDepth = 16
+	minRequestQueueDepth     = 2
+	// How often rate estimates are updated.
+	rateInterval = time.Second
+	// How often requests are checked for timeouts.
+	requestTimeoutCheckInterval = 5 * time.Second
+)
+
+func (cl *Client) requestQueueTime() time.Duration {
+	if cl.config.RequestQueueTime > 0 {
+		return cl.config.RequestQueueTime
+	}
+	return defaultRequestQueueTime
+}
+
+func (cl *Client) requestTimeout() time.Duration {
+	if cl.config.RequestTimeout > 0 {
+		return cl.config.RequestTimeout
+	}
+	return defaultRequestTimeout
+}
+
+// The number of requests we'd like to have outstanding with the peer.
+func (cn *connection) requestQueueDepth() int {
+	if cn.snubbed {
+		return 1
+	}
+	if cn.downloadRate.rate == 0 {
+		return initialRequestQueueDepth
+	}
+	secs := (cn.minRequestLatency + cn.t.cl.requestQueueTime()).Seconds()
+	ret := int(cn.downloadRate.rate*secs/float64(cn.t.chunkSize)) + 1
+	if ret < minRequestQueueDepth {
+		ret = minRequestQueueDepth
+	}
+	return ret
+}
+
+// Updates the connection's rate and latency estimates for a chunk of n bytes
+// that arrived in response to r.
+func (cn *connection) measureChunk(r request, n int, now time.Time) {
+	if cn.snubbed {
+		cn.snubbed = false
+		unsnubbedPeers.Add(1)
+	}
+	if sent, ok := cn.Requests[r]; ok {
+		latency := now.Sub(sent)
+		if cn.minRequestLatency == 0 || latency < cn.minRequestLatency {
+			cn.minRequestLatency = latency
+		}
+	}
+	cn.downloadRate.add(n, now)
+}
+
+// An exponentially weighted moving average of a transfer rate in bytes per
+// second, updated at most every rateInterval.
+type rateEstimate struct {
+	rate float64
+	// Bytes transferred since the rate was last updated.
+	bytes int64
+	since time.Time
+}
+
+func (me *rateEstimate) add(n int, now time.Time) {
+	if me.since.IsZero() {
+		me.since = now
+	}
+	me.bytes += int64(n)
+	elapsed := now.Sub(me.since)
+	if elapsed < rateInterval {
+		return
+	}
+	me.rate = me.next(float64(me.bytes) / elapsed.Seconds())
+	me.bytes = 0
+	me.since = now
+}
+
+func (me *rateEstimate) next(rate float64) float64 {
+	if me.rate == 0 {
+		return rate
+	}
+	return 0.7*me.rate + 0.3*rate
+}
+
+// Returns the rate as of now, decayed for the intervals that have passed
+// without an update.
+func (me *rateEstimate) current(now time.Time) float64 {
+	elapsed := now.Sub(me.since)
+	if me.since.IsZero() || elapsed < rateInterval {
+		return me.rate
+	}
+	recent := float64(me.bytes) / elapsed.Seconds()
+	ret := *me
+	for i := time.Duration(0); i < elapsed/rateInterval && i < 32; i++ {
+		ret.rate = ret.next(recent)
+	}
+	return ret.rate
+}
+
+// Periodically snubs peers with requests that have timed out, until the
+// torrent is closed.
+func (t *Torrent) requestTimeoutLoop() {
+	ticker := time.NewTicker(requestTimeoutCheckInterval)
+	defer ticker.Stop()
+	cl := t.cl
+	for {
+		select {
+		case <-ticker.C:
+		case <-t.closed.LockedChan(&cl.mu):
+			return
+		}
+		cl.mu.Lock()
+		t.expireRequests(time.Now())
+		cl.mu.Unlock()
+	}
+}
+
+// Snubs peers with requests outstanding since before the timeout, and hands
+// their requests to other peers.
+func (t *Torrent) expireRequests(now time.Time) {
+	timeout := t.cl.requestTimeout()
+	snubbed := false
+	for _, c := range t.conns {
+		if !c.requestTimedOut(now, timeout) {
+			continue
+		}
+		c.snubbed = true
+		snubbedPeers.Add(1)
+		for r := range c.Requests {
+			t.cl.connCancel(t, c, r)
+		}
+		snubbed = true
+	}
+	if !snubbed {
+		return
+	}
+	// Let the peers that aren't snubbed have first pick.
+	for _, c := range t.conns {
+		if !c.snubbed {
+			c.updateRequests()
+		}
+	}
+	for _, c := range t.conns {
+		if c.snubbed {
+			c.updateRequests()
+		}
+	}
+}
+
+func (cn *connection) requestTimedOut(now time.Time, timeout time.Duration) bool {
+	for _, sent := range cn.Requests {
+		if now.Sub(sent) >= timeout {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package torrent
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Feeds the connection a chunk every interval for a couple of seconds, with
+// the first satisfied after latency.
+func feedChunks(c *connection, latency, interval time.Duration) {
+	start := time.Now()
+	r := newRequest(0, 0, defaultChunkSize)
+	c.Requests = map[request]time.Time{r: start}
+	now := start.Add(latency)
+	for now.Sub(start) < 2*time.Second {
+		c.measureChunk(r, defaultChunkSize, now)
+		now = now.Add(interval)
+	}
+}
+
+func TestRequestQueueDepthAdapts(t *testing.T) {
+	tt := &Torrent{cl: &Client{}, chunkSize: defaultChunkSize}
+	near := &connection{t: tt, PeerMaxRequests: 250}
+	far := &connection{t: tt, PeerMaxRequests: 250}
+	slow := &connection{t: tt, PeerMaxRequests: 250}
+	assert.Equal(t, initialRequestQueueDepth, near.requestQueueDepth())
+	feedChunks(near, 10*time.Millisecond, 10*time.Millisecond)
+	feedChunks(far, 500*time.Millisecond, 10*time.Millisecond)
+	feedChunks(slow, 10*time.Millisecond, 500*time.Millisecond)
+	assert.InEpsilon(t, 100*defaultChunkSize, near.downloadRate.rate, 0.05)
+	// A longer round-trip needs a deeper queue at the same rate.
+	assert.True(t, far.requestQueueDepth() > near.requestQueueDepth())
+	assert.True(t, slow.requestQueueDepth() < near.requestQueueDepth())
+	assert.Equal(t, 250, far.nominalMaxRequests())
+}
+
+func TestExpiredRequestsGoToOtherPeers(t *testing.T) {
+	cfg := TestingConfig
+	cfg.EndGameMaxDuplicates = 1
+	cl, err := NewClient(&cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt := endGameTestTorrent(t, cl)
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	newConn := func() *connection {
+		c := &connection{
+			t:               tt,
+			PeerMaxRequests: 250,
+		}
+		c.peerPieces.Set(0, true)
+		tt.addConn(c)
+		return c
+	}
+	c1, c2 := newConn(), newConn()
+	c1.updatePiecePriority(0)
+	c2.updatePiecePriority(0)
+	require.Len(t, c1.Requests, 2)
+	require.Len(t, c2.Requests, 0)
+	tt.expireRequests(time.Now())
+	assert.False(t, c1.snubbed)
+	tt.expireRequests(time.Now().Add(cl.requestTimeout()))
+	assert.True(t, c1.snubbed)
+	assert.Len(t, c1.Requests, 0)
+	assert.Len(t, c2.Requests, 2)
+	assert.Equal(t, 1, c1.nominalMaxRequests())
+	c1.measureChunk(newRequest(0, 0, defaultChunkSize), defaultChunkSize, time.Now())
+	assert.False(t, c1.snubbed)
+}
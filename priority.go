@@ -0,0 +1,100 @@
+package torrent
+
+import (
+	"github.com/anacrolix/torrent/storage"
+)
+
+// A download priority set on pieces with Torrent.SetPiecePriority or
+// File.SetPriority. Pieces Readers need are still requested first.
+type DownloadPriority int
+
+const (
+	// Pieces are downloaded if marked with DownloadPieces or DownloadAll.
+	DownloadPriorityDefault DownloadPriority = iota
+	// Not downloaded unless a Reader needs them.
+	DownloadPrioritySkip
+	// Downloaded after other wanted pieces.
+	DownloadPriorityLow
+	DownloadPriorityNormal
+	// Downloaded before other wanted pieces.
+	DownloadPriorityHigh
+)
+
+// The number of distinct values of DownloadPriority.orderRank.
+const numDownloadPriorityRanks = 3
+
+// Returns true if pieces with the priority are downloaded.
+func (me DownloadPriority) wanted() bool {
+	return me >= DownloadPriorityLow
+}
+
+// Groups pieces for requesting, lowest first.
+func (me DownloadPriority) orderRank() int {
+	switch me {
+	case DownloadPriorityHigh:
+		return 0
+	case DownloadPriorityLow:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// Sets the download priority of the pieces. Those that change are stored
+// together.
+func (t *Torrent) setPieceDownloadPriorities(pieces []int, prio DownloadPriority) {
+	var changed []int
+	for _, piece := range pieces {
+		p := &t.pieces[piece]
+		if p.downloadPriority == prio {
+			continue
+		}
+		p.downloadPriority = prio
+		changed = append(changed, piece)
+	}
+	if len(changed) == 0 {
+		return
+	}
+	if ps, ok := t.storage.(storage.PiecePriorityTorrent); ok {
+		ps.SetPiecePriorities(changed, int(prio))
+	}
+	for _, piece := range changed {
+		t.applyDownloadPriority(piece)
+	}
+}
+
+// Pends or unpends the piece according to its download priority, and has
+// connections reorder it.
+func (t *Torrent) applyDownloadPriority(piece int) {
+	switch prio := t.pieces[piece].downloadPriority; {
+	case prio == DownloadPrioritySkip:
+		t.pendingPieces.Remove(piece)
+	case prio.wanted() && !t.havePiece(piece):
+		t.pendingPieces.Add(piece)
+	}
+	t.updatePiecePriority(piece)
+	t.piecePriorityChanged(piece)
+}
+
+// Restores the download priorities kept by storage.
+func (t *Torrent) loadDownloadPriorities() {
+	ps, ok := t.storage.(storage.PiecePriorityTorrent)
+	if !ok {
+		return
+	}
+	for i := range t.pieces {
+		prio := DownloadPriority(ps.PiecePriority(i))
+		if prio == DownloadPriorityDefault {
+			continue
+		}
+		t.pieces[i].downloadPriority = prio
+		t.applyDownloadPriority(i)
+	}
+}
+
+// Returns true if prio can be set on a piece shared with other files,
+// without lowering it below what they might need.
+func (t *Torrent) raisesPieceDownloadPriority(piece int, prio DownloadPriority) bool {
+	cur := t.pieces[piece].downloadPriority
+	return prio.wanted() && (!cur.wanted() || prio.orderRank() < cur.orderRank())
+}
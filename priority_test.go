@@ -0,0 +1,82 @@
+package torrent
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+func testPriorityTorrent(t *testing.T, cl *Client) *Torrent {
+	ie := metainfo.InfoEx{
+		Info: metainfo.Info{
+			Name:        "priority",
+			PieceLength: defaultChunkSize,
+			Pieces:      bytes.Repeat([]byte{1}, 3*20),
+			Files: []metainfo.FileInfo{
+				{Path: []string{"a"}, Length: defaultChunkSize * 3 / 2},
+				{Path: []string{"b"}, Length: defaultChunkSize * 3 / 2},
+			},
+		},
+	}
+	ie.UpdateBytes()
+	tt, _, err := cl.AddTorrentSpec(&TorrentSpec{
+		Info:     &ie,
+		InfoHash: ie.Hash(),
+	})
+	require.NoError(t, err)
+	return tt
+}
+
+func TestFileSetPriority(t *testing.T) {
+	cl, err := NewClient(&TestingConfig)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt := testPriorityTorrent(t, cl)
+	files := tt.Files()
+	files[0].SetPriority(DownloadPriorityHigh)
+	files[1].SetPriority(DownloadPriorityLow)
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	prios := func() (ret []DownloadPriority) {
+		for i := range tt.pieces {
+			ret = append(ret, tt.pieces[i].downloadPriority)
+		}
+		return
+	}
+	// The shared piece isn't lowered by the second file.
+	assert.Equal(t, []DownloadPriority{DownloadPriorityHigh, DownloadPriorityHigh, DownloadPriorityLow}, prios())
+	assert.Equal(t, []int{0, 1, 2}, tt.pendingPieces.ToSortedSlice())
+	cl.mu.Unlock()
+	files[0].SetPriority(DownloadPrioritySkip)
+	cl.mu.Lock()
+	assert.Equal(t, []DownloadPriority{DownloadPrioritySkip, DownloadPriorityHigh, DownloadPriorityLow}, prios())
+	assert.Equal(t, []int{1, 2}, tt.pendingPieces.ToSortedSlice())
+}
+
+func TestPiecePriorityPersisted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	cfg := TestingConfig
+	cfg.DataDir = dir
+	cl, err := NewClient(&cfg)
+	require.NoError(t, err)
+	tt := testPriorityTorrent(t, cl)
+	tt.SetPiecePriority(2, DownloadPriorityHigh)
+	cl.Close()
+	cl, err = NewClient(&cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt = testPriorityTorrent(t, cl)
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	assert.Equal(t, DownloadPriorityHigh, tt.pieces[2].downloadPriority)
+	assert.Equal(t, DownloadPriorityDefault, tt.pieces[1].downloadPriority)
+	assert.Equal(t, []int{2}, tt.pendingPieces.ToSortedSlice())
+}
@@ -0,0 +1,253 @@
+package torrent
+
+import (
+	"sort"
+	"time"
+)
+
+// Limits on how many torrents are active at once. Torrents beyond the limits
+// wait in the Client's queue, stopped as if paused, and are started in queue
+// order as slots free up. Zero values mean no limit. See
+// Client.SetQueueConfig.
+type QueueConfig struct {
+	// Torrents still getting their info or wanting data.
+	MaxActiveDownloads int
+	// Complete torrents that are seeding.
+	MaxActiveSeeds int
+	// A download that completes no data for this long is stalled. It gives
+	// up its slot to the next torrent in the queue, and is only started
+	// again when no torrents that aren't stalled are waiting.
+	StallTimeout time.Duration
+	// Seeding stops when the torrent has uploaded this multiple of its
+	// length.
+	SeedRatio float64
+	// Seeding stops when the torrent has been seeding for this long.
+	SeedTime time.Duration
+}
+
+// How often downloads are checked for progress, and seeding time counted.
+const queueInterval = time.Second
+
+func (cl *Client) QueueConfig() QueueConfig {
+	cl.mu.RLock()
+	defer cl.mu.RUnlock()
+	return cl.config.Queue
+}
+
+// Changes the queue limits. Torrents are started or stopped straight away
+// to meet them.
+func (cl *Client) SetQueueConfig(qc QueueConfig) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	cl.config.Queue = qc
+	cl.updateQueue(time.Now())
+	cl.event.Broadcast()
+}
+
+func (cl *Client) queueing() bool {
+	return cl.config.Queue != QueueConfig{}
+}
+
+func (cl *Client) queueLoop() {
+	ticker := time.NewTicker(queueInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-cl.closed.LockedChan(&cl.mu):
+			return
+		}
+		cl.mu.Lock()
+		cl.updateQueue(time.Now())
+		cl.mu.Unlock()
+	}
+}
+
+// Returns the torrent's position in the Client's queue, from 0.
+func (t *Torrent) QueuePosition() int {
+	t.cl.mu.RLock()
+	defer t.cl.mu.RUnlock()
+	return t.cl.queuePosition(t)
+}
+
+// Moves the torrent to the position in the Client's queue, shifting those
+// after it back. Positions past the end move it to the end.
+func (t *Torrent) SetQueuePosition(pos int) {
+	cl := t.cl
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	i := cl.queuePosition(t)
+	if i < 0 {
+		return
+	}
+	cl.queue = append(cl.queue[:i], cl.queue[i+1:]...)
+	if pos < 0 {
+		pos = 0
+	}
+	if pos > len(cl.queue) {
+		pos = len(cl.queue)
+	}
+	cl.queue = append(cl.queue, nil)
+	copy(cl.queue[pos+1:], cl.queue[pos:])
+	cl.queue[pos] = t
+	cl.updateQueue(time.Now())
+	cl.event.Broadcast()
+}
+
+// Returns true if the torrent is stopped waiting in the Client's queue, or
+// because it reached the seeding limits.
+func (t *Torrent) Queued() bool {
+	t.cl.mu.RLock()
+	defer t.cl.mu.RUnlock()
+	return t.queued
+}
+
+func (cl *Client) queuePosition(t *Torrent) int {
+	for i, t1 := range cl.queue {
+		if t1 == t {
+			return i
+		}
+	}
+	return -1
+}
+
+func (cl *Client) removeFromQueue(t *Torrent) {
+	if i := cl.queuePosition(t); i >= 0 {
+		cl.queue = append(cl.queue[:i], cl.queue[i+1:]...)
+	}
+}
+
+// Returns true if the torrent has seeded enough. Complete torrents only.
+func (t *Torrent) seedLimitReached() bool {
+	qc := t.cl.config.Queue
+	if !t.haveInfo() {
+		return false
+	}
+	if qc.SeedRatio != 0 && t.length != 0 && float64(t.uploadedBytes) >= qc.SeedRatio*float64(t.length) {
+		return true
+	}
+	return qc.SeedTime != 0 && t.seedingTime >= qc.SeedTime
+}
+
+// Called when the torrent receives data. A stalled download is no longer
+// stalled.
+func (t *Torrent) madeProgress() {
+	t.lastProgress = time.Now()
+	if !t.stalledSince.IsZero() {
+		t.stalledSince = time.Time{}
+		t.updateState()
+	}
+}
+
+// Counts the seeding time, and checks a download for progress. Returns true
+// if the download has just stalled.
+func (t *Torrent) updateQueueProgress(now time.Time, elapsed time.Duration, needData bool) (stalled bool) {
+	if t.stopped() {
+		return
+	}
+	if !needData && t.seedAllowed() {
+		t.seedingTime += elapsed
+	}
+	stallTimeout := t.cl.config.Queue.StallTimeout
+	if stallTimeout == 0 {
+		t.stalledSince = time.Time{}
+		return
+	}
+	if t.lastProgress.IsZero() {
+		t.lastProgress = now
+	}
+	if t.stalledSince.IsZero() && needData && now.Sub(t.lastProgress) >= stallTimeout {
+		t.stalledSince = now
+		return true
+	}
+	return
+}
+
+// Sorts torrents by how long they've been stalled, longest first, so that
+// stalled downloads take turns.
+type torrentsByStalled []*Torrent
+
+func (me torrentsByStalled) Len() int      { return len(me) }
+func (me torrentsByStalled) Swap(i, j int) { me[i], me[j] = me[j], me[i] }
+
+func (me torrentsByStalled) Less(i, j int) bool {
+	return me[i].stalledSince.Before(me[j].stalledSince)
+}
+
+// Starts and stops torrents to fit the QueueConfig, in queue order. Paused
+// torrents are passed over, and don't count toward the limits.
+func (cl *Client) updateQueue(now time.Time) {
+	var elapsed time.Duration
+	if !cl.queueUpdated.IsZero() {
+		elapsed = now.Sub(cl.queueUpdated)
+	}
+	cl.queueUpdated = now
+	if !cl.queueing() {
+		for _, t := range cl.queue {
+			if t.queued {
+				t.setStopped(t.paused, false)
+			}
+		}
+		return
+	}
+	qc := cl.config.Queue
+	under := func(max, n int) bool {
+		return max == 0 || n < max
+	}
+	active := make(map[*Torrent]bool, len(cl.queue))
+	var (
+		downloads, seeds int
+		stalled          torrentsByStalled
+		// Those that just stalled, but may not change queued.
+		newlyStalled []*Torrent
+	)
+	for _, t := range cl.queue {
+		if t.paused {
+			continue
+		}
+		needData := t.needData()
+		if t.updateQueueProgress(now, elapsed, needData) {
+			newlyStalled = append(newlyStalled, t)
+		}
+		switch {
+		case needData:
+			if !t.stalledSince.IsZero() {
+				stalled = append(stalled, t)
+			} else if under(qc.MaxActiveDownloads, downloads) {
+				downloads++
+				active[t] = true
+			}
+		case t.seedLimitReached():
+		case t.seedAllowed():
+			if under(qc.MaxActiveSeeds, seeds) {
+				seeds++
+				active[t] = true
+			}
+		default:
+			// Complete, and not seeding, so there's nothing for it to do
+			// either way.
+			active[t] = true
+		}
+	}
+	sort.Stable(stalled)
+	for _, t := range stalled {
+		if !under(qc.MaxActiveDownloads, downloads) {
+			break
+		}
+		downloads++
+		active[t] = true
+		if t.queued {
+			// It gets another StallTimeout to make progress.
+			t.stalledSince = time.Time{}
+		}
+	}
+	for _, t := range cl.queue {
+		if t.paused {
+			continue
+		}
+		t.setStopped(false, !active[t])
+	}
+	for _, t := range newlyStalled {
+		t.updateState()
+	}
+}
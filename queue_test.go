@@ -0,0 +1,118 @@
+package torrent
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/torrent/internal/testutil"
+)
+
+func TestQueueMaxActiveDownloads(t *testing.T) {
+	cfg := TestingConfig
+	cfg.Queue.MaxActiveDownloads = 1
+	cl, err := NewClient(&cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	t1 := testTorrentPieces(t, cl, 2)
+	t2 := testTorrentPieces(t, cl, 3)
+	cl.mu.Lock()
+	cl.updateQueue(time.Now())
+	assert.False(t, t1.queued)
+	assert.True(t, t2.queued)
+	assert.Equal(t, TorrentQueued, t2.state())
+	assert.False(t, t2.wantPeers())
+	cl.mu.Unlock()
+	assert.Equal(t, 1, t2.QueuePosition())
+	t2.SetQueuePosition(0)
+	assert.Equal(t, 0, t2.QueuePosition())
+	assert.Equal(t, 1, t1.QueuePosition())
+	assert.True(t, t1.Queued())
+	assert.False(t, t2.Queued())
+	// A paused torrent gives up its slot.
+	t2.Pause()
+	assert.False(t, t1.Queued())
+	// It's ahead in the queue, so it takes the slot back.
+	t2.Resume()
+	assert.False(t, t2.Queued())
+	assert.True(t, t1.Queued())
+	cl.SetQueueConfig(QueueConfig{})
+	assert.False(t, t1.Queued())
+	assert.False(t, t2.Queued())
+	t1.Drop()
+	assert.Equal(t, 0, t2.QueuePosition())
+}
+
+func TestQueueStalledDownloadsTakeTurns(t *testing.T) {
+	cfg := TestingConfig
+	cfg.Queue = QueueConfig{
+		MaxActiveDownloads: 1,
+		StallTimeout:       time.Minute,
+	}
+	cl, err := NewClient(&cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	t1 := testTorrentPieces(t, cl, 2)
+	t2 := testTorrentPieces(t, cl, 3)
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	now := time.Now()
+	cl.updateQueue(now)
+	assert.False(t, t1.queued)
+	assert.True(t, t2.queued)
+	now = now.Add(2 * time.Minute)
+	cl.updateQueue(now)
+	assert.Equal(t, now, t1.stalledSince)
+	assert.True(t, t1.queued)
+	assert.False(t, t2.queued)
+	// Both are stalled, and t1 has been waiting longest.
+	cl.updateQueue(now.Add(2 * time.Minute))
+	assert.False(t, t1.queued)
+	assert.True(t, t1.stalledSince.IsZero())
+	assert.True(t, t2.queued)
+	assert.Equal(t, TorrentQueued, t2.state())
+	// Receiving data ends a stall.
+	t1.stalledSince = now
+	t1.updateState()
+	require.Equal(t, TorrentStalled, t1.state())
+	t1.madeProgress()
+	assert.True(t, t1.stalledSince.IsZero())
+	assert.Equal(t, TorrentDownloading, t1.publishedState)
+}
+
+func TestQueueSeedLimits(t *testing.T) {
+	greetingTempDir, mi := testutil.GreetingTestTorrent()
+	defer os.RemoveAll(greetingTempDir)
+	cfg := TestingConfig
+	cfg.Seed = true
+	cfg.DataDir = greetingTempDir
+	cfg.Queue.SeedTime = time.Hour
+	cl, err := NewClient(&cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt, err := cl.AddTorrent(mi)
+	require.NoError(t, err)
+	tt.VerifyData()
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	now := time.Now()
+	cl.updateQueue(now)
+	require.True(t, tt.seeding())
+	assert.False(t, tt.queued)
+	cl.updateQueue(now.Add(time.Hour))
+	assert.True(t, tt.queued)
+	var buf bytes.Buffer
+	tt.writeStatus(&buf, cl)
+	assert.Contains(t, buf.String(), "State: finished\n")
+	// Raising the limit lets it seed again.
+	cl.config.Queue = QueueConfig{SeedRatio: 2}
+	cl.updateQueue(now.Add(time.Hour))
+	assert.False(t, tt.queued)
+	tt.uploadedBytes = 2 * tt.length
+	cl.updateQueue(now.Add(time.Hour))
+	assert.True(t, tt.queued)
+}
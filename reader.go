@@ -5,6 +5,7 @@ import (
 	"io"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/anacrolix/missinggo"
 	"golang.org/x/net/context"
@@ -14,6 +15,11 @@ import (
 type Reader struct {
 	t          *Torrent
 	responsive bool
+	// The region of the torrent's data that the Reader covers. Positions
+	// are relative to offset. A negative length extends to the end of the
+	// torrent.
+	offset int64
+	length int64
 	// Ensure operations that change the position are exclusive, like Read()
 	// and Seek().
 	opMu sync.Mutex
@@ -23,10 +29,23 @@ type Reader struct {
 	mu        sync.Mutex
 	pos       int64
 	readahead int64
+	// When streaming, the data at deadlinePos is needed by deadline, and
+	// each byte after it 1/bitrate seconds later.
+	bitrate     int64
+	deadline    time.Time
+	deadlinePos int64
 }
 
 var _ io.ReadCloser = &Reader{}
 
+// The length of the data the Reader covers.
+func (r *Reader) dataLength() int64 {
+	if r.length < 0 {
+		return r.t.length - r.offset
+	}
+	return r.length
+}
+
 // Don't wait for pieces to complete and be verified. Read calls return as
 // soon as they can when the underlying chunks become available.
 func (r *Reader) SetResponsive() {
@@ -102,12 +121,14 @@ func (r *Reader) ReadContext(b []byte, ctx context.Context) (n int, err error) {
 		ctx, cancel := context.WithCancel(ctx)
 		// Abort the goroutine when the function returns.
 		defer cancel()
+		// The Reader may be closed by the time this runs.
+		cl := r.t.cl
 		go func() {
 			<-ctx.Done()
-			r.t.cl.mu.Lock()
+			cl.mu.Lock()
 			ctxErr = ctx.Err()
-			r.t.cl.event.Broadcast()
-			r.t.cl.mu.Unlock()
+			cl.event.Broadcast()
+			cl.mu.Unlock()
 		}()
 	}
 	// Hmmm, if a Read gets stuck, this means you can't change position for
@@ -129,7 +150,7 @@ func (r *Reader) ReadContext(b []byte, ctx context.Context) (n int, err error) {
 		r.pos += int64(n1)
 		r.mu.Unlock()
 	}
-	if r.pos >= r.t.length {
+	if r.pos >= r.dataLength() {
 		err = io.EOF
 	} else if err == io.EOF {
 		err = io.ErrUnexpectedEOF
@@ -148,12 +169,16 @@ func (r *Reader) waitAvailable(pos, wanted int64, ctxErr *error) (avail int64) {
 	return r.available(pos, wanted)
 }
 
-// Performs at most one successful read to torrent storage.
+// Performs at most one successful read to torrent storage. pos is relative
+// to the Reader's offset.
 func (r *Reader) readOnceAt(b []byte, pos int64, ctxErr *error) (n int, err error) {
-	if pos >= r.t.length {
+	left := r.dataLength() - pos
+	if left <= 0 {
 		err = io.EOF
 		return
 	}
+	missinggo.LimitLen(&b, left)
+	pos += r.offset
 	for {
 		avail := r.waitAvailable(pos, int64(len(b)), ctxErr)
 		if avail == 0 {
@@ -207,11 +232,15 @@ func (r *Reader) Seek(off int64, whence int) (ret int64, err error) {
 	case os.SEEK_CUR:
 		r.pos += off
 	case os.SEEK_END:
-		r.pos = r.t.info.TotalLength() + off
+		r.pos = r.dataLength() + off
 	default:
 		err = errors.New("bad whence")
 	}
 	ret = r.pos
+	if !r.deadline.IsZero() {
+		r.deadline = time.Now()
+		r.deadlinePos = r.pos
+	}
 	r.mu.Unlock()
 
 	r.posChanged()
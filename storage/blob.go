@@ -0,0 +1,266 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/anacrolix/missinggo"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// A store of immutable objects, such as an S3 bucket. Keys are opaque
+// strings without path separators. Errors for missing objects must satisfy
+// os.IsNotExist.
+type BlobStore interface {
+	// Reads from an object at the given offset. Behaves like
+	// io.ReaderAt.ReadAt.
+	ReadAt(key string, b []byte, off int64) (int, error)
+	// Creates an object from the size bytes read from r. If the object
+	// exists, it's replaced.
+	Put(key string, r io.Reader, size int64) error
+	// Returns the size of an object.
+	Stat(key string) (size int64, err error)
+	Delete(key string) error
+}
+
+// Stores each completed piece as an object in a BlobStore, keyed by the piece
+// hash. Identical pieces are stored once, regardless of the torrent they're
+// in. Pieces are written to files in incompleteDir until they're complete.
+type blobPieces struct {
+	store         BlobStore
+	incompleteDir string
+}
+
+func NewBlobPieces(store BlobStore, incompleteDir string) Client {
+	return &blobPieces{
+		store:         store,
+		incompleteDir: incompleteDir,
+	}
+}
+
+func (s *blobPieces) OpenTorrent(info *metainfo.InfoEx) (Torrent, error) {
+	return &blobTorrent{s: s}, nil
+}
+
+type blobTorrent struct {
+	s  *blobPieces
+	mu sync.Mutex
+	// Pieces that failed a check. Other torrents may still be using their
+	// objects, so they're only ignored by this torrent, until it completes
+	// the piece again and replaces the object.
+	bad map[int]bool
+}
+
+func (t *blobTorrent) Close() error {
+	return nil
+}
+
+func (t *blobTorrent) Piece(p metainfo.Piece) Piece {
+	return blobPiece{t.s, t, p}
+}
+
+func (t *blobTorrent) isBad(index int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.bad[index]
+}
+
+func (t *blobTorrent) setBad(index int, bad bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !bad {
+		delete(t.bad, index)
+		return
+	}
+	if t.bad == nil {
+		t.bad = make(map[int]bool)
+	}
+	t.bad[index] = true
+}
+
+type blobPiece struct {
+	s *blobPieces
+	t *blobTorrent
+	p metainfo.Piece
+}
+
+func (bp blobPiece) key() string {
+	return bp.p.Hash().HexString()
+}
+
+func (bp blobPiece) incompletePath() string {
+	return filepath.Join(bp.s.incompleteDir, bp.key())
+}
+
+func (bp blobPiece) GetIsComplete() bool {
+	if bp.t.isBad(bp.p.Index()) {
+		return false
+	}
+	return bp.stored()
+}
+
+func (bp blobPiece) stored() bool {
+	size, err := bp.s.store.Stat(bp.key())
+	return err == nil && size == bp.p.Length()
+}
+
+func (bp blobPiece) MarkComplete() error {
+	bad := bp.t.isBad(bp.p.Index())
+	if !bad && bp.stored() {
+		// Another torrent got here first.
+		return bp.removeIncomplete()
+	}
+	// A bad object is replaced by the data that has just passed.
+	f, err := os.Open(bp.incompletePath())
+	if os.IsNotExist(err) && bp.stored() {
+		// Torrents share the incomplete data, and another completed it
+		// first.
+		bp.t.setBad(bp.p.Index(), false)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	err = bp.s.store.Put(bp.key(), io.NewSectionReader(f, 0, bp.p.Length()), bp.p.Length())
+	if err != nil {
+		return err
+	}
+	bp.t.setBad(bp.p.Index(), false)
+	return bp.removeIncomplete()
+}
+
+// Removes the incomplete data, which another torrent completing the same
+// piece may have done already.
+func (bp blobPiece) removeIncomplete() error {
+	err := os.Remove(bp.incompletePath())
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return err
+}
+
+// The object is left for any other torrents sharing it. This torrent
+// downloads the piece again.
+func (bp blobPiece) MarkNotComplete() error {
+	bp.t.setBad(bp.p.Index(), true)
+	return nil
+}
+
+func (bp blobPiece) ReadAt(b []byte, off int64) (n int, err error) {
+	missinggo.LimitLen(&b, bp.p.Length()-off)
+	if bp.t.isBad(bp.p.Index()) {
+		err = os.ErrNotExist
+	} else {
+		n, err = bp.s.store.ReadAt(bp.key(), b, off)
+	}
+	if os.IsNotExist(err) {
+		n, err = bp.readIncompleteAt(b, off)
+	}
+	off += int64(n)
+	if off >= bp.p.Length() {
+		err = io.EOF
+	} else if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return
+}
+
+func (bp blobPiece) readIncompleteAt(b []byte, off int64) (n int, err error) {
+	f, err := os.Open(bp.incompletePath())
+	if os.IsNotExist(err) {
+		err = io.ErrUnexpectedEOF
+		return
+	}
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	return f.ReadAt(b, off)
+}
+
+func (bp blobPiece) WriteAt(b []byte, off int64) (n int, err error) {
+	if bp.GetIsComplete() {
+		err = errors.New("piece completed")
+		return
+	}
+	err = os.MkdirAll(bp.s.incompleteDir, 0750)
+	if err != nil {
+		return
+	}
+	f, err := os.OpenFile(bp.incompletePath(), os.O_WRONLY|os.O_CREATE, 0640)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	missinggo.LimitLen(&b, bp.p.Length()-off)
+	return f.WriteAt(b, off)
+}
+
+// A BlobStore keeping each object as a file in a directory.
+type dirBlobStore struct {
+	dir string
+}
+
+func NewDirBlobStore(dir string) BlobStore {
+	return dirBlobStore{dir}
+}
+
+func (me dirBlobStore) path(key string) string {
+	return filepath.Join(me.dir, key)
+}
+
+func (me dirBlobStore) ReadAt(key string, b []byte, off int64) (int, error) {
+	f, err := os.Open(me.path(key))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return f.ReadAt(b, off)
+}
+
+// Writes to a temporary file first, so that objects appear whole or not at
+// all.
+func (me dirBlobStore) Put(key string, r io.Reader, size int64) (err error) {
+	err = os.MkdirAll(me.dir, 0750)
+	if err != nil {
+		return
+	}
+	f, err := ioutil.TempFile(me.dir, key+".")
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(f.Name())
+		}
+	}()
+	_, err = io.CopyN(f, r, size)
+	if err != nil {
+		f.Close()
+		return
+	}
+	err = f.Close()
+	if err != nil {
+		return
+	}
+	return os.Rename(f.Name(), me.path(key))
+}
+
+func (me dirBlobStore) Stat(key string) (size int64, err error) {
+	fi, err := os.Stat(me.path(key))
+	if err != nil {
+		return
+	}
+	size = fi.Size()
+	return
+}
+
+func (me dirBlobStore) Delete(key string) error {
+	return os.Remove(me.path(key))
+}
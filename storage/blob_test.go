@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"crypto/sha1"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+func TestBlobPiecesDeduplicated(t *testing.T) {
+	td, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(td)
+	store := NewDirBlobStore(filepath.Join(td, "blobs"))
+	s := NewBlobPieces(store, filepath.Join(td, "incomplete"))
+	data := []byte("hello, world\n")
+	hash := sha1.Sum(data)
+	newInfo := func(name string) *metainfo.InfoEx {
+		return &metainfo.InfoEx{
+			Info: metainfo.Info{
+				Name:        name,
+				Length:      int64(len(data)),
+				PieceLength: int64(len(data)),
+				Pieces:      hash[:],
+			},
+		}
+	}
+	a, b := newInfo("a"), newInfo("b")
+	ta, err := s.OpenTorrent(a)
+	require.NoError(t, err)
+	tb, err := s.OpenTorrent(b)
+	require.NoError(t, err)
+	pa := ta.Piece(a.Piece(0))
+	pb := tb.Piece(b.Piece(0))
+	_, err = pa.WriteAt(data[:5], 0)
+	require.NoError(t, err)
+	_, err = pa.WriteAt(data[5:], 5)
+	require.NoError(t, err)
+	assert.False(t, pb.GetIsComplete())
+	require.NoError(t, pa.MarkComplete())
+	assert.True(t, pb.GetIsComplete())
+	_, err = os.Stat(filepath.Join(td, "incomplete", metainfo.Hash(hash).HexString()))
+	assert.True(t, os.IsNotExist(err))
+	buf := make([]byte, len(data))
+	n, err := pb.ReadAt(buf, 0)
+	assert.Equal(t, io.EOF, err)
+	assert.EqualValues(t, len(data), n)
+	assert.Equal(t, data, buf)
+	_, err = pb.WriteAt(data, 0)
+	assert.Error(t, err)
+	// b failing the piece doesn't take it from a.
+	require.NoError(t, pb.MarkNotComplete())
+	assert.False(t, pb.GetIsComplete())
+	assert.True(t, pa.GetIsComplete())
+	n, err = pa.ReadAt(buf, 0)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, data, buf[:n])
+	// b downloads it again, and replaces the object.
+	_, err = pb.WriteAt(data, 0)
+	require.NoError(t, err)
+	require.NoError(t, pb.MarkComplete())
+	assert.True(t, pb.GetIsComplete())
+	assert.True(t, pa.GetIsComplete())
+}
+
+// Torrents with the same piece share its incomplete data, and may both
+// complete it.
+func TestBlobPiecesCompletedTwice(t *testing.T) {
+	td, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(td)
+	s := NewBlobPieces(NewDirBlobStore(filepath.Join(td, "blobs")), filepath.Join(td, "incomplete"))
+	data := []byte("hello, world\n")
+	hash := sha1.Sum(data)
+	var ps []Piece
+	for _, name := range []string{"a", "b"} {
+		info := &metainfo.InfoEx{
+			Info: metainfo.Info{
+				Name:        name,
+				Length:      int64(len(data)),
+				PieceLength: int64(len(data)),
+				Pieces:      hash[:],
+			},
+		}
+		ts, err := s.OpenTorrent(info)
+		require.NoError(t, err)
+		p := ts.Piece(info.Piece(0))
+		_, err = p.WriteAt(data, 0)
+		require.NoError(t, err)
+		ps = append(ps, p)
+	}
+	require.NoError(t, ps[0].MarkComplete())
+	require.NoError(t, ps[1].MarkComplete())
+	assert.True(t, ps[1].GetIsComplete())
+	// The same again, with b replacing a piece it found bad.
+	require.NoError(t, ps[1].MarkNotComplete())
+	require.NoError(t, ps[1].MarkComplete())
+	assert.True(t, ps[1].GetIsComplete())
+}
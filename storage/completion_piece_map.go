@@ -5,7 +5,8 @@ import (
 )
 
 type mapPieceCompletion struct {
-	m map[metainfo.PieceKey]struct{}
+	m          map[metainfo.PieceKey]struct{}
+	priorities map[metainfo.PieceKey]int
 }
 
 func (mapPieceCompletion) Close() {}
@@ -25,3 +26,20 @@ func (me *mapPieceCompletion) Set(p metainfo.Piece, b bool) {
 		delete(me.m, p.Key())
 	}
 }
+
+func (me *mapPieceCompletion) GetPriority(p metainfo.Piece) int {
+	return me.priorities[p.Key()]
+}
+
+func (me *mapPieceCompletion) SetPriority(ps []metainfo.Piece, prio int) {
+	for _, p := range ps {
+		if prio != 0 {
+			if me.priorities == nil {
+				me.priorities = make(map[metainfo.PieceKey]int)
+			}
+			me.priorities[p.Key()] = prio
+		} else {
+			delete(me.priorities, p.Key())
+		}
+	}
+}
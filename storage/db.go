@@ -22,6 +22,11 @@ func newDBPieceCompletion(dir string) (ret *dbPieceCompletion, err error) {
 		db.Close()
 		return
 	}
+	_, err = db.Exec(`create table if not exists priorities(infohash, "index", priority, unique(infohash, "index") on conflict replace)`)
+	if err != nil {
+		db.Close()
+		return
+	}
 	ret = &dbPieceCompletion{db}
 	return
 }
@@ -47,6 +52,42 @@ func (me *dbPieceCompletion) Set(p metainfo.Piece, b bool) {
 	}
 }
 
+func (me *dbPieceCompletion) GetPriority(p metainfo.Piece) (ret int) {
+	row := me.db.QueryRow(`select priority from priorities where infohash=? and "index"=?`, p.Info.Hash().HexString(), p.Index())
+	err := row.Scan(&ret)
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// The pieces are written in a single transaction, as a file's priority can
+// cover a great many of them.
+func (me *dbPieceCompletion) SetPriority(ps []metainfo.Piece, prio int) {
+	tx, err := me.db.Begin()
+	if err != nil {
+		panic(err)
+	}
+	for _, p := range ps {
+		if prio != 0 {
+			_, err = tx.Exec(`insert into priorities (infohash, "index", priority) values (?, ?, ?)`, p.Info.Hash().HexString(), p.Index(), prio)
+		} else {
+			_, err = tx.Exec(`delete from priorities where infohash=? and "index"=?`, p.Info.Hash().HexString(), p.Index())
+		}
+		if err != nil {
+			tx.Rollback()
+			panic(err)
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		panic(err)
+	}
+}
+
 func (me *dbPieceCompletion) Close() {
 	me.db.Close()
 }
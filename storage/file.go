@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"errors"
 	"io"
 	"os"
 	"path/filepath"
@@ -14,6 +15,10 @@ import (
 type pieceCompletion interface {
 	Get(metainfo.Piece) bool
 	Set(metainfo.Piece, bool)
+	// Download priorities are kept with completion, as they're both state
+	// that should survive the torrent being added again.
+	GetPriority(metainfo.Piece) int
+	SetPriority([]metainfo.Piece, int)
 	Close()
 }
 
@@ -22,6 +27,7 @@ type pieceCompletion interface {
 type fileStorage struct {
 	baseDir    string
 	completion pieceCompletion
+	readOnly   bool
 }
 
 func NewFile(baseDir string) Client {
@@ -31,13 +37,44 @@ func NewFile(baseDir string) Client {
 	}
 }
 
+// Returned from writes to read-only storage.
+var ErrReadOnly = errors.New("storage is read-only")
+
+// File-based storage that never creates or modifies anything under baseDir,
+// for seeding from read-only mounts and snapshots. Piece completion is only
+// kept in memory, so existing data is hashed each time a torrent is added,
+// and pieces are complete only if they match. Writes return ErrReadOnly.
+func NewFileReadOnly(baseDir string) Client {
+	return &fileStorage{
+		baseDir:    baseDir,
+		completion: new(mapPieceCompletion),
+		readOnly:   true,
+	}
+}
+
 func (fs *fileStorage) OpenTorrent(info *metainfo.InfoEx) (Torrent, error) {
-	return fileTorrentStorage{fs}, nil
+	return fileTorrentStorage{fs, info}, nil
 }
 
 // File-based torrent storage, not yet bound to a Torrent.
 type fileTorrentStorage struct {
 	*fileStorage
+	info *metainfo.InfoEx
+}
+
+func (fts fileTorrentStorage) PiecePriority(index int) int {
+	return fts.completion.GetPriority(fts.info.Piece(index))
+}
+
+func (fts fileTorrentStorage) SetPiecePriorities(indices []int, priority int) {
+	fts.completion.SetPriority(infoPieces(fts.info, indices), priority)
+}
+
+func infoPieces(info *metainfo.InfoEx, indices []int) (ret []metainfo.Piece) {
+	for _, i := range indices {
+		ret = append(ret, info.Piece(i))
+	}
+	return
 }
 
 func (fs *fileStorage) Piece(p metainfo.Piece) Piece {
@@ -45,6 +82,7 @@ func (fs *fileStorage) Piece(p metainfo.Piece) Piece {
 	_io := &fileStorageTorrent{
 		p.Info,
 		fs.baseDir,
+		fs.readOnly,
 	}
 	// Return the appropriate segments of this.
 	return &fileStoragePiece{
@@ -76,14 +114,35 @@ func (fs *fileStoragePiece) MarkComplete() error {
 	return nil
 }
 
+func (fs *fileStoragePiece) MarkNotComplete() error {
+	fs.completion.Set(fs.p, false)
+	return nil
+}
+
+func (fs *fileStoragePiece) HasHoles() bool {
+	return regionHasHoles(&fs.p.Info.Info, fs.baseDir, fs.p.Offset(), fs.p.Length())
+}
+
 // Exposes file-based storage of a torrent, as one big ReadWriterAt.
 type fileStorageTorrent struct {
-	info    *metainfo.InfoEx
-	baseDir string
+	info     *metainfo.InfoEx
+	baseDir  string
+	readOnly bool
 }
 
 // Returns EOF on short or missing file.
 func (fst *fileStorageTorrent) readFileAt(fi metainfo.FileInfo, b []byte, off int64) (n int, err error) {
+	if fi.IsPadding() {
+		// Padding isn't stored, and is all zeroes.
+		if int64(len(b)) > fi.Length-off {
+			b = b[:fi.Length-off]
+		}
+		for i := range b {
+			b[i] = 0
+		}
+		n = len(b)
+		return
+	}
 	f, err := os.Open(fst.fileInfoName(fi))
 	if os.IsNotExist(err) {
 		// File missing is treated the same as a short file.
@@ -141,6 +200,10 @@ func (fst *fileStorageTorrent) ReadAt(b []byte, off int64) (n int, err error) {
 }
 
 func (fst *fileStorageTorrent) WriteAt(p []byte, off int64) (n int, err error) {
+	if fst.readOnly {
+		err = ErrReadOnly
+		return
+	}
 	for _, fi := range fst.info.UpvertedFiles() {
 		if off >= fi.Length {
 			off -= fi.Length
@@ -150,6 +213,15 @@ func (fst *fileStorageTorrent) WriteAt(p []byte, off int64) (n int, err error) {
 		if int64(n1) > fi.Length-off {
 			n1 = int(fi.Length - off)
 		}
+		if fi.IsPadding() {
+			n += n1
+			off = 0
+			p = p[n1:]
+			if len(p) == 0 {
+				break
+			}
+			continue
+		}
 		name := fst.fileInfoName(fi)
 		os.MkdirAll(filepath.Dir(name), 0770)
 		var f *os.File
@@ -38,3 +38,88 @@ func TestShortFile(t *testing.T) {
 	assert.EqualValues(t, 1, n)
 	assert.Equal(t, io.ErrUnexpectedEOF, err)
 }
+
+func TestReadOnlyFile(t *testing.T) {
+	td, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(td)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(td, "a"), []byte("hello"), 0644))
+	s := NewFileReadOnly(td)
+	defer s.(io.Closer).Close()
+	info := &metainfo.InfoEx{
+		Info: metainfo.Info{
+			Name:        "a",
+			Length:      5,
+			PieceLength: missinggo.MiB,
+		},
+	}
+	ts, err := s.OpenTorrent(info)
+	require.NoError(t, err)
+	p := ts.Piece(info.Piece(0))
+	assert.False(t, p.GetIsComplete())
+	b := make([]byte, 5)
+	n, err := p.ReadAt(b, 0)
+	assert.EqualValues(t, 5, n)
+	assert.Equal(t, "hello", string(b))
+	_, err = p.WriteAt([]byte("j"), 0)
+	assert.Equal(t, ErrReadOnly, err)
+	names, err := ioutil.ReadDir(td)
+	require.NoError(t, err)
+	assert.Len(t, names, 1)
+}
+
+func TestFilePiecePriorities(t *testing.T) {
+	td, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(td)
+	info := &metainfo.InfoEx{
+		Info: metainfo.Info{
+			Name:        "a",
+			Length:      3,
+			PieceLength: 1,
+			Pieces:      make([]byte, 3*20),
+		},
+	}
+	info.UpdateBytes()
+	s := NewFile(td)
+	ts, err := s.OpenTorrent(info)
+	require.NoError(t, err)
+	pt := ts.(PiecePriorityTorrent)
+	pt.SetPiecePriorities([]int{0, 2}, 4)
+	assert.Equal(t, 4, pt.PiecePriority(0))
+	assert.Equal(t, 0, pt.PiecePriority(1))
+	assert.Equal(t, 4, pt.PiecePriority(2))
+	pt.SetPiecePriorities([]int{0, 1, 2}, 0)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, 0, pt.PiecePriority(i))
+	}
+}
+
+// Pad files aren't stored, and so aren't holes in the data.
+func TestFilePaddingNotHoles(t *testing.T) {
+	td, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(td)
+	info := &metainfo.InfoEx{
+		Info: metainfo.Info{
+			Name: "t",
+			Files: []metainfo.FileInfo{
+				{Path: []string{"a"}, Length: 1},
+				{Path: []string{".pad", "3"}, Length: 3, Attr: "p"},
+				{Path: []string{"b"}, Length: 4},
+			},
+			PieceLength: 8,
+			Pieces:      make([]byte, 20),
+		},
+	}
+	require.NoError(t, os.MkdirAll(filepath.Join(td, "t"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(td, "t", "a"), []byte("a"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(td, "t", "b"), []byte("bbbb"), 0644))
+	for _, s := range []Client{NewFile(td), NewMMap(td)} {
+		ts, err := s.OpenTorrent(info)
+		require.NoError(t, err)
+		p := ts.Piece(info.Piece(0))
+		assert.False(t, p.(SparsePiece).HasHoles())
+		ts.Close()
+	}
+}
@@ -27,6 +27,40 @@ type Piece interface {
 	// The storage can move or mark the piece data as read-only as it sees
 	// fit.
 	MarkComplete() error
+	// Called when the piece data has failed a hash check, such as during a
+	// recheck of data that was previously marked complete.
+	MarkNotComplete() error
 	// Returns true if the piece is complete.
 	GetIsComplete() bool
 }
+
+// Optionally implemented by Torrent storage that can use knowing which pieces
+// Readers are positioned in or reading ahead into, such as to avoid evicting
+// them. Each call replaces the previous set of piece indices.
+type ReaderAwareTorrent interface {
+	SetReaderPieces(indices []int)
+}
+
+// Optionally implemented by Torrent storage that drops complete pieces by
+// itself, such as to stay within a capacity. f is called with the index of
+// each piece dropped, without any storage locks held.
+type EvictingTorrent interface {
+	SetOnEvicted(f func(index int))
+}
+
+// Optionally implemented by Torrent storage that can keep the download
+// priorities set on pieces, so they're restored when the torrent is added
+// again. Priorities are opaque, and zero means none is set.
+type PiecePriorityTorrent interface {
+	PiecePriority(index int) int
+	// Sets the same priority on several pieces at once.
+	SetPiecePriorities(indices []int, priority int)
+}
+
+// Optionally implemented by Pieces that can cheaply determine that some of
+// their data has never been written, such as when it falls in a sparse file
+// hole. A piece with holes can't pass a hash check, so there's no need to
+// read it.
+type SparsePiece interface {
+	HasHoles() bool
+}
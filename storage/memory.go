@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"container/list"
+	"io"
+	"sync"
+
+	"github.com/anacrolix/missinggo"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// Piece storage in RAM, shared by all torrents opened from it. When storing a
+// new piece would take the total over capacity bytes, complete pieces are
+// evicted, least recently read first. Pieces that Readers are positioned in
+// or reading ahead into are only evicted if there's nothing else. An evicted
+// piece is no longer complete, so it will be downloaded again if it's wanted.
+// Torrents are told of their evicted pieces through EvictingTorrent.
+type memoryStorage struct {
+	capacity int64
+
+	mu   sync.Mutex
+	used int64
+	// Complete pieces, with the most recently read at the front.
+	lru list.List
+}
+
+func NewMemory(capacity int64) Client {
+	return &memoryStorage{
+		capacity: capacity,
+	}
+}
+
+func (s *memoryStorage) OpenTorrent(info *metainfo.InfoEx) (Torrent, error) {
+	return &memoryTorrent{
+		s:      s,
+		pieces: make(map[int]*memoryPiece),
+	}, nil
+}
+
+// Makes room for n more bytes if possible. Must be called with s.mu held.
+// Returns the notifications of evicted pieces, to be run once s.mu is
+// released.
+func (s *memoryStorage) reserve(n int64) (notify []func()) {
+	for _, evictRetained := range []bool{false, true} {
+		for e := s.lru.Back(); e != nil && s.used+n > s.capacity; {
+			mp := e.Value.(*memoryPiece)
+			e = e.Prev()
+			if mp.retained && !evictRetained {
+				continue
+			}
+			mp.evict()
+			if f := mp.t.onEvicted; f != nil {
+				index := mp.index
+				notify = append(notify, func() { f(index) })
+			}
+		}
+	}
+	s.used += n
+	return
+}
+
+type memoryTorrent struct {
+	s      *memoryStorage
+	pieces map[int]*memoryPiece
+	// Called with the index of each piece evicted to make room for others.
+	onEvicted func(index int)
+}
+
+func (t *memoryTorrent) Piece(p metainfo.Piece) Piece {
+	return memoryPieceHandle{t, p}
+}
+
+func (t *memoryTorrent) Close() error {
+	t.s.mu.Lock()
+	defer t.s.mu.Unlock()
+	for _, mp := range t.pieces {
+		mp.evict()
+	}
+	return nil
+}
+
+func (t *memoryTorrent) SetOnEvicted(f func(index int)) {
+	t.s.mu.Lock()
+	defer t.s.mu.Unlock()
+	t.onEvicted = f
+}
+
+// Marks the pieces that Readers are interested in, replacing the previous
+// set.
+func (t *memoryTorrent) SetReaderPieces(indices []int) {
+	t.s.mu.Lock()
+	defer t.s.mu.Unlock()
+	for _, mp := range t.pieces {
+		mp.retained = false
+	}
+	for _, i := range indices {
+		t.piece(i).retained = true
+	}
+}
+
+// Must be called with s.mu held.
+func (t *memoryTorrent) piece(index int) *memoryPiece {
+	mp, ok := t.pieces[index]
+	if !ok {
+		mp = &memoryPiece{s: t.s, t: t, index: index}
+		t.pieces[index] = mp
+	}
+	return mp
+}
+
+type memoryPiece struct {
+	s        *memoryStorage
+	t        *memoryTorrent
+	index    int
+	data     []byte
+	complete bool
+	retained bool
+	// Set while the piece is complete.
+	lruElem *list.Element
+}
+
+// Drops the piece data. Must be called with s.mu held.
+func (mp *memoryPiece) evict() {
+	if mp.lruElem != nil {
+		mp.s.lru.Remove(mp.lruElem)
+		mp.lruElem = nil
+	}
+	mp.s.used -= int64(len(mp.data))
+	mp.data = nil
+	mp.complete = false
+}
+
+type memoryPieceHandle struct {
+	t *memoryTorrent
+	p metainfo.Piece
+}
+
+func (me memoryPieceHandle) lock() *memoryPiece {
+	me.t.s.mu.Lock()
+	return me.t.piece(me.p.Index())
+}
+
+func (me memoryPieceHandle) unlock() {
+	me.t.s.mu.Unlock()
+}
+
+func (me memoryPieceHandle) GetIsComplete() bool {
+	defer me.unlock()
+	return me.lock().complete
+}
+
+func (me memoryPieceHandle) MarkComplete() error {
+	defer me.unlock()
+	mp := me.lock()
+	if mp.data == nil {
+		return io.ErrUnexpectedEOF
+	}
+	if !mp.complete {
+		mp.complete = true
+		mp.lruElem = me.t.s.lru.PushFront(mp)
+	}
+	return nil
+}
+
+func (me memoryPieceHandle) MarkNotComplete() error {
+	defer me.unlock()
+	mp := me.lock()
+	if mp.lruElem != nil {
+		me.t.s.lru.Remove(mp.lruElem)
+		mp.lruElem = nil
+	}
+	mp.complete = false
+	return nil
+}
+
+func (me memoryPieceHandle) ReadAt(b []byte, off int64) (n int, err error) {
+	defer me.unlock()
+	mp := me.lock()
+	if off >= me.p.Length() {
+		err = io.EOF
+		return
+	}
+	if mp.data == nil {
+		err = io.ErrUnexpectedEOF
+		return
+	}
+	if mp.lruElem != nil {
+		me.t.s.lru.MoveToFront(mp.lruElem)
+	}
+	missinggo.LimitLen(&b, me.p.Length()-off)
+	n = copy(b, mp.data[off:])
+	if off+int64(n) >= me.p.Length() {
+		err = io.EOF
+	}
+	return
+}
+
+func (me memoryPieceHandle) WriteAt(b []byte, off int64) (n int, err error) {
+	mp := me.lock()
+	if off >= me.p.Length() {
+		me.unlock()
+		return
+	}
+	var notify []func()
+	if mp.data == nil {
+		notify = me.t.s.reserve(me.p.Length())
+		mp.data = make([]byte, me.p.Length())
+	}
+	missinggo.LimitLen(&b, me.p.Length()-off)
+	n = copy(mp.data[off:], b)
+	me.unlock()
+	for _, f := range notify {
+		f()
+	}
+	return
+}
@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+func TestMemoryEviction(t *testing.T) {
+	info := &metainfo.InfoEx{
+		Info: metainfo.Info{
+			Name:        "a",
+			Length:      4,
+			PieceLength: 1,
+			Pieces:      make([]byte, 4*20),
+		},
+	}
+	s := NewMemory(2)
+	ts, err := s.OpenTorrent(info)
+	require.NoError(t, err)
+	var evicted []int
+	ts.(EvictingTorrent).SetOnEvicted(func(index int) {
+		evicted = append(evicted, index)
+	})
+	piece := func(i int) Piece { return ts.Piece(info.Piece(i)) }
+	complete := func(i int) {
+		_, err := piece(i).WriteAt([]byte{byte(i)}, 0)
+		require.NoError(t, err)
+		require.NoError(t, piece(i).MarkComplete())
+	}
+	complete(0)
+	complete(1)
+	// Reading 0 makes 1 the least recently read.
+	piece(0).ReadAt(make([]byte, 1), 0)
+	complete(2)
+	assert.True(t, piece(0).GetIsComplete())
+	assert.False(t, piece(1).GetIsComplete())
+	assert.True(t, piece(2).GetIsComplete())
+	assert.Equal(t, []int{1}, evicted)
+	// A Reader positioned over 0 keeps it around despite being the least
+	// recently read.
+	ts.(ReaderAwareTorrent).SetReaderPieces([]int{0})
+	complete(3)
+	assert.True(t, piece(0).GetIsComplete())
+	assert.False(t, piece(2).GetIsComplete())
+	assert.True(t, piece(3).GetIsComplete())
+	assert.Equal(t, []int{1, 2}, evicted)
+	_, err = piece(2).ReadAt(make([]byte, 1), 0)
+	assert.Error(t, err)
+}
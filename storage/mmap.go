@@ -28,34 +28,50 @@ func NewMMap(baseDir string) Client {
 func (s *mmapStorage) OpenTorrent(info *metainfo.InfoEx) (t Torrent, err error) {
 	span, err := mMapTorrent(&info.Info, s.baseDir)
 	t = &mmapTorrentStorage{
-		span: span,
-		pc:   s.completion,
+		span:    span,
+		pc:      s.completion,
+		info:    info,
+		baseDir: s.baseDir,
 	}
 	return
 }
 
 type mmapTorrentStorage struct {
-	span mmap_span.MMapSpan
-	pc   pieceCompletion
+	span    mmap_span.MMapSpan
+	pc      pieceCompletion
+	info    *metainfo.InfoEx
+	baseDir string
 }
 
 func (ts *mmapTorrentStorage) Piece(p metainfo.Piece) Piece {
 	return mmapStoragePiece{
 		pc:       ts.pc,
 		p:        p,
+		info:     &ts.info.Info,
+		baseDir:  ts.baseDir,
 		ReaderAt: io.NewSectionReader(ts.span, p.Offset(), p.Length()),
 		WriterAt: missinggo.NewSectionWriter(ts.span, p.Offset(), p.Length()),
 	}
 }
 
+func (ts *mmapTorrentStorage) PiecePriority(index int) int {
+	return ts.pc.GetPriority(ts.info.Piece(index))
+}
+
+func (ts *mmapTorrentStorage) SetPiecePriorities(indices []int, priority int) {
+	ts.pc.SetPriority(infoPieces(ts.info, indices), priority)
+}
+
 func (ts *mmapTorrentStorage) Close() error {
 	ts.span.Close()
 	return nil
 }
 
 type mmapStoragePiece struct {
-	pc pieceCompletion
-	p  metainfo.Piece
+	pc      pieceCompletion
+	p       metainfo.Piece
+	info    *metainfo.Info
+	baseDir string
 	io.ReaderAt
 	io.WriterAt
 }
@@ -69,6 +85,15 @@ func (sp mmapStoragePiece) MarkComplete() error {
 	return nil
 }
 
+func (sp mmapStoragePiece) MarkNotComplete() error {
+	sp.pc.Set(sp.p, false)
+	return nil
+}
+
+func (sp mmapStoragePiece) HasHoles() bool {
+	return regionHasHoles(sp.info, sp.baseDir, sp.p.Offset(), sp.p.Length())
+}
+
 func mMapTorrent(md *metainfo.Info, location string) (mms mmap_span.MMapSpan, err error) {
 	defer func() {
 		if err != nil {
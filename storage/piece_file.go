@@ -60,6 +60,14 @@ func (s pieceFileTorrentStoragePiece) MarkComplete() error {
 	return s.fs.Rename(s.incompletePath(), s.completedPath())
 }
 
+func (s pieceFileTorrentStoragePiece) MarkNotComplete() error {
+	err := s.fs.Remove(s.completedPath())
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return err
+}
+
 func (s pieceFileTorrentStoragePiece) openFile() (f missinggo.File, err error) {
 	f, err = s.fs.OpenFile(s.completedPath(), os.O_RDONLY)
 	if err == nil {
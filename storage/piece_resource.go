@@ -59,6 +59,10 @@ func (s piecePerResourcePiece) MarkComplete() error {
 	return resource.Move(s.i, s.c)
 }
 
+func (s piecePerResourcePiece) MarkNotComplete() error {
+	return s.c.Delete()
+}
+
 func (s piecePerResourcePiece) ReadAt(b []byte, off int64) (n int, err error) {
 	missinggo.LimitLen(&b, s.p.Length()-off)
 	n, err = s.c.ReadAt(b, off)
@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// Returns true if any of the region of the torrent data stored under baseDir
+// is known to have never been written. Missing and short files count as
+// holes. Pad files aren't stored, so they don't.
+func regionHasHoles(info *metainfo.Info, baseDir string, off, n int64) bool {
+	for _, fi := range info.UpvertedFiles() {
+		if n <= 0 {
+			break
+		}
+		if off >= fi.Length {
+			off -= fi.Length
+			continue
+		}
+		n1 := n
+		if n1 > fi.Length-off {
+			n1 = fi.Length - off
+		}
+		name := filepath.Join(append([]string{baseDir, info.Name}, fi.Path...)...)
+		if !fi.IsPadding() && fileRegionHasHoles(name, off, n1) {
+			return true
+		}
+		off = 0
+		n -= n1
+	}
+	return false
+}
+
+func fileRegionHasHoles(name string, off, n int64) bool {
+	f, err := os.Open(name)
+	if err != nil {
+		return os.IsNotExist(err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	if fi.Size() < off+n {
+		return true
+	}
+	return seekHoles(f, off, n)
+}
@@ -0,0 +1,31 @@
+package storage
+
+import (
+	"os"
+	"syscall"
+)
+
+// Whence values for lseek(2) that aren't in package os.
+const (
+	seekData = 3
+	seekHole = 4
+)
+
+// Uses SEEK_DATA and SEEK_HOLE to look for holes in the given region of f. If
+// the filesystem doesn't support them, no holes are reported.
+func seekHoles(f *os.File, off, n int64) bool {
+	data, err := f.Seek(off, seekData)
+	if err != nil {
+		// ENXIO means there's no data from off to the end of the file.
+		pe, ok := err.(*os.PathError)
+		return ok && pe.Err == syscall.ENXIO
+	}
+	if data != off {
+		return true
+	}
+	hole, err := f.Seek(off, seekHole)
+	if err != nil {
+		return false
+	}
+	return hole < off+n
+}
@@ -0,0 +1,10 @@
+// +build !linux
+
+package storage
+
+import "os"
+
+// Hole detection isn't implemented for this platform.
+func seekHoles(f *os.File, off, n int64) bool {
+	return false
+}
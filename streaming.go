@@ -0,0 +1,242 @@
+package torrent
+
+import (
+	"time"
+
+	"github.com/anacrolix/missinggo/bitmap"
+)
+
+// A Reader streaming media consumes data at a steady rate, so each piece
+// ahead of it has a time it's needed by. Readers with a bitrate or deadline
+// give their readahead pieces those deadlines, which the built-in pickers
+// request ahead of everything else. Pieces the Reader has passed lose their
+// deadlines. When a piece's deadline is at risk at the current download
+// rate, its outstanding requests are duplicated to the faster peers, as in
+// end-game.
+
+const (
+	// A piece's deadline is at risk if this close to when it's expected to
+	// complete.
+	deadlineRiskMargin = 2 * time.Second
+	// How long the torrent's download rate is reused for, rather than summed
+	// over its connections on every request fill.
+	downloadRateCacheInterval = time.Second
+)
+
+// Sets the rate in bytes per second that data is read at, such as a media
+// bitrate. The data at the current position is needed now, and the data
+// ahead of it as it would be read at the bitrate. Zero stops streaming.
+func (r *Reader) SetBitrate(bytesPerSecond int64) {
+	r.mu.Lock()
+	if bytesPerSecond > 0 {
+		r.bitrate = bytesPerSecond
+		r.deadline = time.Now()
+		r.deadlinePos = r.pos
+	} else {
+		r.bitrate = 0
+		r.deadline = time.Time{}
+	}
+	r.mu.Unlock()
+	r.t.cl.mu.Lock()
+	defer r.t.cl.mu.Unlock()
+	r.tickleClient()
+}
+
+// Sets when the data at the current position is needed by. The data after
+// it is needed as it would be read at the bitrate, or all by the deadline
+// if there's no bitrate. Seeking moves the deadline to now, at the new
+// position.
+func (r *Reader) SetDeadline(deadline time.Time) {
+	r.mu.Lock()
+	r.deadline = deadline
+	r.deadlinePos = r.pos
+	r.mu.Unlock()
+	r.t.cl.mu.Lock()
+	defer r.t.cl.mu.Unlock()
+	r.tickleClient()
+}
+
+// Returns when reads at the bitrate are expected to catch up with the data
+// that's available, at the torrent's current download rate. ok is false if
+// there's no bitrate, or downloading is keeping up.
+func (r *Reader) PredictStall() (at time.Time, ok bool) {
+	r.mu.Lock()
+	pos, bitrate := r.pos, r.bitrate
+	deadline, deadlinePos := r.deadline, r.deadlinePos
+	r.mu.Unlock()
+	if bitrate == 0 {
+		return
+	}
+	r.t.cl.mu.RLock()
+	defer r.t.cl.mu.RUnlock()
+	left := r.dataLength() - pos
+	if left <= 0 {
+		return
+	}
+	buffered := r.available(r.offset+pos, left)
+	if buffered == left {
+		return
+	}
+	now := time.Now()
+	rate := r.t.downloadRate(now)
+	if rate >= float64(bitrate) {
+		return
+	}
+	// Until the buffered data runs out, if nothing more arrives.
+	slack := streamDeadline(deadline, deadlinePos, bitrate, pos+buffered).Sub(now)
+	if slack < 0 {
+		slack = 0
+	}
+	// The buffer drains by the difference in the rates.
+	slack = time.Duration(float64(slack) * float64(bitrate) / (float64(bitrate) - rate))
+	return now.Add(slack), true
+}
+
+// Returns when the data at pos is needed, for data at deadlinePos needed by
+// deadline and read at bitrate after that.
+func streamDeadline(deadline time.Time, deadlinePos, bitrate, pos int64) time.Time {
+	if bitrate == 0 || pos <= deadlinePos {
+		return deadline
+	}
+	secs := float64(pos-deadlinePos) / float64(bitrate)
+	return deadline.Add(time.Duration(secs * float64(time.Second)))
+}
+
+// Gives the pieces in the readahead of streaming Readers their deadlines,
+// and has connections reorder those that changed. Only the pieces in those
+// readaheads, and those that had deadlines before, are visited.
+func (t *Torrent) updateReaderDeadlines() {
+	if !t.haveInfo() {
+		return
+	}
+	var deadlines map[int]time.Time
+	for r := range t.readers {
+		r.mu.Lock()
+		bitrate, deadline, deadlinePos := r.bitrate, r.deadline, r.deadlinePos
+		r.mu.Unlock()
+		if deadline.IsZero() {
+			continue
+		}
+		if deadlines == nil {
+			deadlines = make(map[int]time.Time)
+		}
+		begin, end := t.readerPieceRange(r)
+		for i := begin; i < end; i++ {
+			d := streamDeadline(deadline, deadlinePos, bitrate, int64(i)*t.info.PieceLength-r.offset)
+			if cur, ok := deadlines[i]; !ok || d.Before(cur) {
+				deadlines[i] = d
+			}
+		}
+	}
+	if deadlines == nil && t.readerDeadlinePieces.IsEmpty() {
+		return
+	}
+	had := t.readerDeadlinePieces
+	t.readerDeadlinePieces = bitmap.Bitmap{}
+	had.IterTyped(func(piece int) bool {
+		if _, ok := deadlines[piece]; !ok {
+			t.setReaderDeadline(piece, time.Time{})
+		}
+		return true
+	})
+	for piece, d := range deadlines {
+		t.setReaderDeadline(piece, d)
+	}
+}
+
+func (t *Torrent) setReaderDeadline(piece int, d time.Time) {
+	p := &t.pieces[piece]
+	if t.pieceComplete(piece) {
+		d = time.Time{}
+	}
+	if !d.IsZero() {
+		t.readerDeadlinePieces.Add(piece)
+	}
+	if d.Equal(p.readerDeadline) {
+		return
+	}
+	p.readerDeadline = d
+	for _, c := range t.conns {
+		c.updatePiecePriority(piece)
+	}
+}
+
+// Returns the earliest of the piece's deadlines, or zero if it has none.
+func (t *Torrent) pieceDeadline(piece int) time.Time {
+	p := &t.pieces[piece]
+	if p.deadline.IsZero() || !p.readerDeadline.IsZero() && p.readerDeadline.Before(p.deadline) {
+		return p.readerDeadline
+	}
+	return p.deadline
+}
+
+// The estimated bytes per second being received for the torrent.
+func (t *Torrent) downloadRate(now time.Time) (ret float64) {
+	for _, c := range t.conns {
+		ret += c.downloadRate.current(now)
+	}
+	return
+}
+
+// Returns the torrent's download rate, recomputed at most once per
+// downloadRateCacheInterval.
+func (t *Torrent) cachedDownloadRate(now time.Time) float64 {
+	if now.Sub(t.downloadRateAt) >= downloadRateCacheInterval {
+		t.lastDownloadRate = t.downloadRate(now)
+		t.downloadRateAt = now
+	}
+	return t.lastDownloadRate
+}
+
+// Returns true if the piece isn't expected to complete before its deadline,
+// at the given download rate.
+func (t *Torrent) pieceDeadlineAtRisk(piece int, rate float64, now time.Time) bool {
+	d := t.pieceDeadline(piece)
+	if d.IsZero() {
+		return false
+	}
+	if rate <= 0 {
+		return true
+	}
+	remaining := float64(t.pieceNumPendingChunks(piece)) * float64(t.chunkSize)
+	eta := time.Duration(remaining / rate * float64(time.Second))
+	return d.Sub(now) < eta+deadlineRiskMargin
+}
+
+// Duplicates the outstanding requests for pieces with deadlines at risk, if
+// the peer is among the faster ones. Only the pieces with deadlines are
+// visited.
+func (cn *connection) requestDeadlinesAtRisk() {
+	t := cn.t
+	if t.readerDeadlinePieces.IsEmpty() && t.deadlinePieces.IsEmpty() {
+		return
+	}
+	now := time.Now()
+	mine := cn.downloadRate.current(now)
+	if cn.snubbed || mine == 0 {
+		return
+	}
+	// Compare with the mean rate of the torrent's peers.
+	rate := t.cachedDownloadRate(now)
+	if mine*float64(len(t.conns)) < rate {
+		return
+	}
+	more := func(piece int) bool {
+		if t.piecePriority(piece) == PiecePriorityNone || !t.pieces[piece].mayRequestFrom(cn) {
+			return true
+		}
+		if !t.pieceDeadlineAtRisk(piece, rate, now) {
+			return true
+		}
+		return t.connRequestPieceDuplicateChunks(cn, piece)
+	}
+	if !t.readerDeadlinePieces.IterTyped(more) {
+		return
+	}
+	t.deadlinePieces.IterTyped(func(piece int) bool {
+		if t.readerDeadlinePieces.Contains(piece) {
+			return true
+		}
+		return more(piece)
+	})
+}
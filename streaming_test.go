@@ -0,0 +1,95 @@
+package torrent
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReaderBitrateDeadlines(t *testing.T) {
+	cl, err := NewClient(&TestingConfig)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt := testTorrentPieces(t, cl, 4)
+	r := tt.NewReader()
+	defer r.Close()
+	r.SetBitrate(defaultChunkSize)
+	cl.mu.Lock()
+	c := testAddConn(tt)
+	require.NoError(t, c.peerSentHaveAll())
+	assert.Equal(t, []int{0, 1, 2, 3}, requestOrder(c))
+	d0, d3 := tt.pieces[0].readerDeadline, tt.pieces[3].readerDeadline
+	assert.InDelta(t, 3*time.Second, d3.Sub(d0), float64(time.Millisecond))
+	cl.mu.Unlock()
+	r.Seek(2*defaultChunkSize, 0)
+	cl.mu.Lock()
+	// The pieces passed have no deadline, and the next is due now.
+	assert.True(t, tt.pieces[1].readerDeadline.IsZero())
+	assert.True(t, tt.pieces[2].readerDeadline.Before(d0.Add(time.Second)))
+	cl.mu.Unlock()
+	at, ok := r.PredictStall()
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now(), at, time.Second)
+	r.SetBitrate(0)
+	_, ok = r.PredictStall()
+	assert.False(t, ok)
+	cl.mu.Lock()
+	assert.True(t, tt.pieces[2].readerDeadline.IsZero())
+	assert.True(t, tt.readerDeadlinePieces.IsEmpty())
+	cl.mu.Unlock()
+}
+
+func TestDeadlineAtRiskDuplicatesRequests(t *testing.T) {
+	cl, err := NewClient(&TestingConfig)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt := testTorrentPieces(t, cl, 6)
+	r := tt.NewReader()
+	defer r.Close()
+	r.SetBitrate(defaultChunkSize)
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	slow := testAddConn(tt)
+	slow.PeerMaxRequests = 2
+	require.NoError(t, slow.peerSentHaveAll())
+	slow.fillRequests()
+	assert.True(t, slow.RequestPending(newRequest(0, 0, defaultChunkSize)))
+	assert.True(t, slow.RequestPending(newRequest(1, 0, defaultChunkSize)))
+	fast := testAddConn(tt)
+	fast.downloadRate = rateEstimate{rate: 1e6, since: time.Now()}
+	require.NoError(t, fast.peerSentBitfield([]bool{true, true, false, false, false, false, false, false}))
+	fast.fillRequests()
+	// The remaining pieces haven't been requested, so this isn't end-game.
+	assert.False(t, tt.endGame())
+	assert.Len(t, fast.Requests, 2)
+	assert.EqualValues(t, 2, tt.pendingRequests[newRequest(0, 0, defaultChunkSize)])
+}
+
+func TestPieceDeadlineAtRiskDuplicatesRequests(t *testing.T) {
+	cl, err := NewClient(&TestingConfig)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt := testTorrentPieces(t, cl, 6)
+	tt.DownloadAll()
+	tt.DownloadPiecesBy(0, 2, time.Now())
+	cl.mu.Lock()
+	slow := testAddConn(tt)
+	slow.PeerMaxRequests = 2
+	require.NoError(t, slow.peerSentHaveAll())
+	slow.fillRequests()
+	assert.True(t, slow.RequestPending(newRequest(0, 0, defaultChunkSize)))
+	assert.True(t, slow.RequestPending(newRequest(1, 0, defaultChunkSize)))
+	fast := testAddConn(tt)
+	fast.downloadRate = rateEstimate{rate: 1e6, since: time.Now()}
+	require.NoError(t, fast.peerSentBitfield([]bool{true, true, false, false, false, false, false, false}))
+	fast.fillRequests()
+	assert.False(t, tt.endGame())
+	assert.EqualValues(t, 2, tt.pendingRequests[newRequest(0, 0, defaultChunkSize)])
+	cl.mu.Unlock()
+	tt.CancelPieces(0, 2)
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	assert.True(t, tt.deadlinePieces.IsEmpty())
+}
@@ -2,7 +2,7 @@ package torrent
 
 import (
 	"fmt"
-	"strings"
+	"time"
 
 	"github.com/anacrolix/missinggo/pubsub"
 
@@ -23,6 +23,14 @@ func (t *Torrent) GotInfo() <-chan struct{} {
 	return t.gotMetainfo.C()
 }
 
+// Returns a channel that is closed when the torrent is dropped, or its Client
+// is closed.
+func (t *Torrent) Closed() <-chan struct{} {
+	t.cl.mu.Lock()
+	defer t.cl.mu.Unlock()
+	return t.closed.C()
+}
+
 // Returns the metainfo info dictionary, or nil if it's not yet available.
 func (t *Torrent) Info() *metainfo.InfoEx {
 	return t.info
@@ -31,8 +39,14 @@ func (t *Torrent) Info() *metainfo.InfoEx {
 // Returns a Reader bound to the torrent's data. All read calls block until
 // the data requested is actually available.
 func (t *Torrent) NewReader() (ret *Reader) {
+	return t.newReader(0, -1)
+}
+
+func (t *Torrent) newReader(offset, length int64) (ret *Reader) {
 	ret = &Reader{
 		t:         t,
+		offset:    offset,
+		length:    length,
 		readahead: 5 * 1024 * 1024,
 	}
 	t.addReader(ret)
@@ -76,8 +90,23 @@ func (t *Torrent) BytesCompleted() int64 {
 	return t.bytesCompleted()
 }
 
-// The subscription emits as (int) the index of pieces as their state changes.
-// A state change is when the PieceState for a piece alters in value.
+// Number of bytes of chunks received that were no longer wanted, usually
+// because they were requested from several peers in end-game.
+func (t *Torrent) BytesWasted() int64 {
+	t.cl.mu.RLock()
+	defer t.cl.mu.RUnlock()
+	return t.wastedBytes
+}
+
+// Number of bytes of chunk data sent to peers.
+func (t *Torrent) BytesUploaded() int64 {
+	t.cl.mu.RLock()
+	defer t.cl.mu.RUnlock()
+	return t.uploadedBytes
+}
+
+// The subscription emits a PieceStateChange for pieces as their state
+// changes. A state change is when the PieceState for a piece alters in value.
 func (t *Torrent) SubscribePieceStateChanges() *pubsub.Subscription {
 	return t.pieceStateChanges.Subscribe()
 }
@@ -146,33 +175,52 @@ func (t *Torrent) DownloadPieces(begin, end int) {
 	t.pendPieceRange(begin, end)
 }
 
+// Marks the range of pieces for download, and requests them ahead of
+// others, with those due soonest first.
+func (t *Torrent) DownloadPiecesBy(begin, end int, deadline time.Time) {
+	t.cl.mu.Lock()
+	defer t.cl.mu.Unlock()
+	for i := begin; i < end; i++ {
+		t.pieces[i].deadline = deadline
+		t.deadlinePieces.Add(i)
+		t.piecePriorityChanged(i)
+	}
+	t.pendPieceRange(begin, end)
+}
+
 func (t *Torrent) CancelPieces(begin, end int) {
 	t.cl.mu.Lock()
 	defer t.cl.mu.Unlock()
+	for i := begin; i < end; i++ {
+		t.pieces[i].deadline = time.Time{}
+		t.deadlinePieces.Remove(i)
+	}
 	t.unpendPieceRange(begin, end)
 }
 
+// Sets the download priority of a piece. This is kept by storage that
+// supports it. Requires the info first, see GotInfo.
+func (t *Torrent) SetPiecePriority(piece int, prio DownloadPriority) {
+	t.cl.mu.Lock()
+	defer t.cl.mu.Unlock()
+	t.setPieceDownloadPriorities([]int{piece}, prio)
+}
+
+// Sets the order pieces are requested in for this torrent, overriding
+// Config.PiecePicker.
+func (t *Torrent) SetPiecePicker(pp PiecePicker) {
+	t.cl.mu.Lock()
+	defer t.cl.mu.Unlock()
+	t.picker = pp
+	t.updateRequestOrders()
+}
+
 // Returns handles to the files in the torrent. This requires the metainfo is
 // available first.
-func (t *Torrent) Files() (ret []File) {
-	t.cl.mu.Lock()
-	info := t.Info()
-	t.cl.mu.Unlock()
-	if info == nil {
-		return
-	}
-	var offset int64
-	for _, fi := range info.UpvertedFiles() {
-		ret = append(ret, File{
-			t,
-			strings.Join(append([]string{info.Name}, fi.Path...), "/"),
-			offset,
-			fi.Length,
-			fi,
-		})
-		offset += fi.Length
-	}
-	return
+func (t *Torrent) Files() []File {
+	t.cl.mu.RLock()
+	defer t.cl.mu.RUnlock()
+	return append([]File(nil), t.files...)
 }
 
 func (t *Torrent) AddPeers(pp []Peer) {
@@ -190,6 +238,31 @@ func (t *Torrent) DownloadAll() {
 	t.pendPieceRange(0, t.numPieces())
 }
 
+// Rehashes every piece, including those already complete, and blocks until
+// they've all been checked or the torrent is closed. Pieces are in the
+// Checking state while waiting, so progress can be followed with
+// SubscribePieceStateChanges. Requires the info first, see GotInfo.
+func (t *Torrent) VerifyData() {
+	cl := t.cl
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	targets := make([]int64, t.numPieces())
+	for i := range targets {
+		p := &t.pieces[i]
+		targets[i] = p.numVerifies + 1
+		if p.Hashing {
+			// The current hash may have read stale data.
+			targets[i]++
+		}
+		t.queueCheck(i)
+	}
+	for i, target := range targets {
+		for t.pieces[i].numVerifies < target && !t.closed.IsSet() {
+			cl.event.Wait()
+		}
+	}
+}
+
 func (t *Torrent) String() string {
 	s := t.name()
 	if s == "" {
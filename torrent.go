@@ -11,6 +11,7 @@ import (
 	"net"
 	"os"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -65,6 +66,9 @@ type Torrent struct {
 	// Set of addrs to which we're attempting to connect. Connections are
 	// half-open until all handshakes are completed.
 	halfOpen map[string]struct{}
+	// Whether the torrent is counted in Client.numWantingConns. See
+	// updateWantsConns.
+	wantingConns bool
 
 	// Reserve of peers to connect to. A peer can be both here and in the
 	// active connections if were told about the peer after connecting with
@@ -86,13 +90,69 @@ type Torrent struct {
 
 	// Set when .Info is obtained.
 	gotMetainfo missinggo.Event
+	// Set while all pieces are complete.
+	completed missinggo.Event
+	// Values are TorrentEvents.
+	events *pubsub.PubSub
+	// The files from the info, and how many of each file's pieces aren't
+	// complete.
+	files          []File
+	filePiecesLeft []int
+	// The state last published in a TorrentStateChanged event.
+	publishedState TorrentState
+	// The pieces being checked after the info was received, or by
+	// VerifyData, rather than after being downloaded.
+	numCheckingPieces int
+	// The last error from storage, until data is written or a piece is
+	// completed successfully.
+	storageErr error
 
 	readers map[*Reader]struct{}
 
 	pendingPieces   bitmap.Bitmap
 	completedPieces bitmap.Bitmap
+	// Pieces whose availability rank has changed, and that conns haven't
+	// reordered yet.
+	availabilityChanged bitmap.Bitmap
+	// Pieces with a readerDeadline.
+	readerDeadlinePieces bitmap.Bitmap
+	// Pieces with a deadline from DownloadPiecesBy.
+	deadlinePieces bitmap.Bitmap
+	// The download rate as of downloadRateAt. See cachedDownloadRate.
+	lastDownloadRate float64
+	downloadRateAt   time.Time
 
 	connPieceInclinationPool sync.Pool
+
+	// Assigns upload slots among conns.
+	choker      choker
+	lastRechoke time.Time
+
+	// The number of conns each outstanding request has been sent to.
+	pendingRequests map[request]int
+	// Chunks of wanted pieces that aren't dirty, and haven't been requested
+	// from any peer. See endGame.
+	numUnrequested int
+	// Bytes of chunks received that were no longer wanted.
+	wastedBytes int64
+	// Bytes of chunks sent to peers.
+	uploadedBytes int64
+
+	// Overrides Config.PiecePicker.
+	picker PiecePicker
+
+	// Set by Pause. No connections are made or accepted, and the torrent
+	// isn't announced.
+	paused bool
+	// Stopped like paused, by the Client's queue. See QueueConfig.
+	queued bool
+	// When the torrent last received data while it wasn't stopped.
+	lastProgress time.Time
+	// Set when a download makes no progress for QueueConfig.StallTimeout.
+	stalledSince time.Time
+	// Time spent seeding while not stopped, counted while the Client is
+	// queueing.
+	seedingTime time.Duration
 }
 
 func (t *Torrent) setDisplayName(dn string) {
@@ -132,7 +192,6 @@ func (t *Torrent) addrActive(addr string) bool {
 func (t *Torrent) worstConns(cl *Client) (wcs *worstConns) {
 	wcs = &worstConns{
 		c:  make([]*connection, 0, len(t.conns)),
-		t:  t,
 		cl: cl,
 	}
 	for _, c := range t.conns {
@@ -222,19 +281,38 @@ func (t *Torrent) setInfoBytes(b []byte) error {
 		return fmt.Errorf("bad info: %s", err)
 	}
 	defer t.updateWantPeersEvent()
+	defer t.updateState()
 	t.info = ie
 	t.cl.event.Broadcast()
 	t.gotMetainfo.Set()
+	t.publishEvent(TorrentEvent{Type: TorrentGotInfo, Info: ie})
 	t.storage, err = t.storageOpener.OpenTorrent(t.info)
 	if err != nil {
+		t.storageErr = err
 		return fmt.Errorf("error opening torrent storage: %s", err)
 	}
+	if es, ok := t.storage.(storage.EvictingTorrent); ok {
+		es.SetOnEvicted(t.pieceEvicted)
+	}
 	t.length = 0
-	for _, f := range t.info.UpvertedFiles() {
-		t.length += f.Length
+	t.files = nil
+	for _, fi := range t.info.UpvertedFiles() {
+		t.files = append(t.files, File{
+			t,
+			strings.Join(append([]string{t.info.Name}, fi.Path...), "/"),
+			t.length,
+			fi.Length,
+			fi,
+		})
+		t.length += fi.Length
 	}
 	t.metadataBytes = b
 	t.metadataCompletedChunks = nil
+	t.filePiecesLeft = make([]int, len(t.files))
+	for i, f := range t.files {
+		begin, end := t.byteRegionPieces(f.offset, f.length)
+		t.filePiecesLeft[i] = end - begin
+	}
 	hashes := infoPieceHashes(&t.info.Info)
 	t.pieces = make([]piece, len(hashes))
 	for i, hash := range hashes {
@@ -243,6 +321,7 @@ func (t *Torrent) setInfoBytes(b []byte) error {
 		piece.index = i
 		piece.noPendingWrites.L = &piece.pendingWritesMutex
 		missinggo.CopyExact(piece.Hash[:], hash)
+		piece.numUnrequested = t.pieceNumChunks(i)
 	}
 	for _, conn := range t.conns {
 		if err := conn.setNumPieces(t.numPieces()); err != nil {
@@ -252,20 +331,14 @@ func (t *Torrent) setInfoBytes(b []byte) error {
 	}
 	for i := range t.pieces {
 		t.updatePieceCompletion(i)
-		t.pieces[i].QueuedForHash = true
-	}
-	go func() {
-		for i := range t.pieces {
-			t.verifyPiece(i)
+		if !t.pieceComplete(i) {
+			t.queueCheck(i)
 		}
-	}()
+	}
+	t.loadDownloadPriorities()
 	return nil
 }
 
-func (t *Torrent) verifyPiece(piece int) {
-	t.cl.verifyPiece(t, piece)
-}
-
 func (t *Torrent) haveAllMetadataPieces() bool {
 	if t.haveInfo() {
 		return true
@@ -393,6 +466,7 @@ func pieceStateRunStatusChars(psr PieceStateRun) (ret string) {
 
 func (t *Torrent) writeStatus(w io.Writer, cl *Client) {
 	fmt.Fprintf(w, "Infohash: %x\n", t.infoHash)
+	fmt.Fprintf(w, "State: %s\n", t.state())
 	fmt.Fprintf(w, "Metadata length: %d\n", t.metadataSize())
 	if !t.haveInfo() {
 		fmt.Fprintf(w, "Metadata have: ")
@@ -443,7 +517,6 @@ func (t *Torrent) writeStatus(w io.Writer, cl *Client) {
 	fmt.Fprintf(w, "Active peers: %d\n", len(t.conns))
 	sort.Sort(&worstConns{
 		c:  t.conns,
-		t:  t,
 		cl: cl,
 	})
 	for i, c := range t.conns {
@@ -520,14 +593,17 @@ func (t *Torrent) numPiecesCompleted() (num int) {
 
 func (t *Torrent) close() (err error) {
 	t.closed.Set()
+	t.cl.event.Broadcast()
 	if c, ok := t.storage.(io.Closer); ok {
 		c.Close()
 	}
 	for _, conn := range t.conns {
-		conn.Close()
+		conn.closeWithReason(ErrConnTorrentClosed)
 	}
 	t.pieceStateChanges.Close()
 	t.updateWantPeersEvent()
+	t.publishEvent(TorrentEvent{Type: TorrentDropped})
+	t.events.Close()
 	return
 }
 
@@ -594,19 +670,31 @@ func (t *Torrent) pieceChunks(piece int) (css []chunkSpec) {
 	return
 }
 
+// Changes the size of chunks requested. Pieces are counted afresh.
+func (t *Torrent) setChunkSize(size pp.Integer) {
+	if size == t.chunkSize {
+		return
+	}
+	t.chunkSize = size
+	for i := range t.pieces {
+		t.recountUnrequested(i)
+	}
+}
+
 func (t *Torrent) pieceNumChunks(piece int) int {
 	return int((t.pieceLength(piece) + t.chunkSize - 1) / t.chunkSize)
 }
 
 func (t *Torrent) pendAllChunkSpecs(pieceIndex int) {
 	t.pieces[pieceIndex].DirtyChunks.Clear()
+	t.recountUnrequested(pieceIndex)
 }
 
 type Peer struct {
 	Id     [20]byte
 	IP     net.IP
 	Port   int
-	Source peerSource
+	Source PeerSource
 	// Peer is known to support encryption.
 	SupportsEncryption bool
 }
@@ -624,15 +712,29 @@ func (t *Torrent) pieceLength(piece int) (len_ pp.Integer) {
 	return
 }
 
-func (t *Torrent) hashPiece(piece int) (ret metainfo.Hash) {
+// If chunkSize isn't zero, the hashes of each chunk are returned too.
+func (t *Torrent) hashPiece(piece int, chunkSize pp.Integer) (ret metainfo.Hash, chunkSums []metainfo.Hash) {
 	hash := pieceHash.New()
 	p := &t.pieces[piece]
 	p.waitNoPendingWrites()
 	ip := t.info.Piece(piece)
 	pl := ip.Length()
-	n, err := io.Copy(hash, io.NewSectionReader(t.pieces[piece].Storage(), 0, pl))
+	var r io.Reader = io.NewSectionReader(t.pieces[piece].Storage(), 0, pl)
+	if t.cl.hashLimiter != nil {
+		r = rateLimitedReader{r, t.cl.hashLimiter}
+	}
+	var w io.Writer = hash
+	var ch *chunkHasher
+	if chunkSize != 0 {
+		ch = &chunkHasher{chunkSize: int64(chunkSize)}
+		w = io.MultiWriter(hash, ch)
+	}
+	n, err := io.Copy(w, r)
 	if n == pl {
 		missinggo.CopyExact(&ret, hash.Sum(nil))
+		if ch != nil {
+			chunkSums = ch.Sums()
+		}
 		return
 	}
 	if err != io.ErrUnexpectedEOF && !os.IsNotExist(err) {
@@ -641,6 +743,13 @@ func (t *Torrent) hashPiece(piece int) (ret metainfo.Hash) {
 	return
 }
 
+// Returns true if the piece storage reports that some of the piece data was
+// never written.
+func (t *Torrent) pieceHasHoles(piece int) bool {
+	sp, ok := t.pieces[piece].Storage().(storage.SparsePiece)
+	return ok && sp.HasHoles()
+}
+
 func (t *Torrent) haveAllPieces() bool {
 	if !t.haveInfo() {
 		return false
@@ -768,6 +877,7 @@ func (t *Torrent) publishPieceChange(piece int) {
 			cur,
 		})
 	}
+	t.updateState()
 }
 
 func (t *Torrent) pieceNumPendingChunks(piece int) int {
@@ -794,6 +904,10 @@ func (t *Torrent) forUrgentPieces(f func(piece int) (again bool)) (all bool) {
 
 func (t *Torrent) readersChanged() {
 	t.updatePiecePriorities()
+	t.updateReaderDeadlines()
+	if ra, ok := t.storage.(storage.ReaderAwareTorrent); ok {
+		ra.SetReaderPieces(t.readerPieces().ToSortedSlice())
+	}
 }
 
 func (t *Torrent) maybeNewConns() {
@@ -817,13 +931,14 @@ func (t *Torrent) updatePiecePriority(piece int) bool {
 		return false
 	}
 	p.priority = newPrio
+	t.syncUnrequested(piece)
 	return true
 }
 
 // Update all piece priorities in one hit. This function should have the same
 // output as updatePiecePriority, but across all pieces.
 func (t *Torrent) updatePiecePriorities() {
-	newPrios := make([]piecePriority, t.numPieces())
+	newPrios := make([]PiecePriority, t.numPieces())
 	t.pendingPieces.IterTyped(func(piece int) (more bool) {
 		newPrios[piece] = PiecePriorityNormal
 		return true
@@ -844,6 +959,7 @@ func (t *Torrent) updatePiecePriorities() {
 	for i, prio := range newPrios {
 		if prio != t.pieces[i].priority {
 			t.pieces[i].priority = prio
+			t.syncUnrequested(i)
 			t.piecePriorityChanged(i)
 		}
 	}
@@ -868,18 +984,28 @@ func (t *Torrent) byteRegionPieces(off, size int64) (begin, end int) {
 	return
 }
 
+// Returns the pieces from the Reader's position to the end of its
+// readahead.
+func (t *Torrent) readerPieceRange(r *Reader) (begin, end int) {
+	r.mu.Lock()
+	pos, readahead := r.pos, r.readahead
+	r.mu.Unlock()
+	if readahead < 1 {
+		readahead = 1
+	}
+	// Don't prioritize beyond the Reader's region.
+	if left := r.dataLength() - pos; readahead > left {
+		readahead = left
+	}
+	return t.byteRegionPieces(r.offset+pos, readahead)
+}
+
 // Returns true if all iterations complete without breaking.
 func (t *Torrent) forReaderOffsetPieces(f func(begin, end int) (more bool)) (all bool) {
 	// There's an oppurtunity here to build a map of beginning pieces, and a
 	// bitmap of the rest. I wonder if it's worth the allocation overhead.
 	for r := range t.readers {
-		r.mu.Lock()
-		pos, readahead := r.pos, r.readahead
-		r.mu.Unlock()
-		if readahead < 1 {
-			readahead = 1
-		}
-		begin, end := t.byteRegionPieces(pos, readahead)
+		begin, end := t.readerPieceRange(r)
 		if begin >= end {
 			continue
 		}
@@ -890,14 +1016,14 @@ func (t *Torrent) forReaderOffsetPieces(f func(begin, end int) (more bool)) (all
 	return true
 }
 
-func (t *Torrent) piecePriority(piece int) piecePriority {
+func (t *Torrent) piecePriority(piece int) PiecePriority {
 	if !t.haveInfo() {
 		return PiecePriorityNone
 	}
 	return t.pieces[piece].priority
 }
 
-func (t *Torrent) piecePriorityUncached(piece int) (ret piecePriority) {
+func (t *Torrent) piecePriorityUncached(piece int) (ret PiecePriority) {
 	ret = PiecePriorityNone
 	if t.pieceComplete(piece) {
 		return
@@ -960,6 +1086,11 @@ func (t *Torrent) connRequestPiecePendingChunks(c *connection, piece int) (more
 	chunkIndices := t.pieces[piece].undirtiedChunkIndices().ToSortedSlice()
 	return itertools.ForPerm(len(chunkIndices), func(i int) bool {
 		req := request{pp.Integer(piece), t.chunkIndexSpec(chunkIndices[i], piece)}
+		if t.pendingRequests[req] != 0 {
+			// Outside end-game, leave it to the peer it's been requested
+			// from.
+			return true
+		}
 		return c.Request(req)
 	})
 }
@@ -998,7 +1129,19 @@ func (t *Torrent) updatePieceCompletion(piece int) {
 	t.completedPieces.Set(piece, pcu)
 	if changed {
 		t.pieceChanged(piece)
+		t.updateFileCompletion(piece)
+	}
+}
+
+// Called by storage when it drops a complete piece by itself. Storage calls
+// this without the Client lock.
+func (t *Torrent) pieceEvicted(piece int) {
+	t.cl.mu.Lock()
+	defer t.cl.mu.Unlock()
+	if t.closed.IsSet() {
+		return
 	}
+	t.updatePieceCompletion(piece)
 }
 
 // Non-blocking read. Client lock is not required.
@@ -1116,6 +1259,12 @@ func (t *Torrent) deleteConnection(c *connection) bool {
 			t.conns[i0] = t.conns[i1]
 		}
 		t.conns = t.conns[:i1]
+		if !t.closed.IsSet() {
+			t.cl.numConns--
+		}
+		c.deleteAllRequests()
+		t.addConnPieceAvailability(c, -1)
+		t.releaseRedownloads(c)
 		return true
 	}
 	return false
@@ -1125,12 +1274,12 @@ func (t *Torrent) dropConnection(c *connection) {
 	t.cl.event.Broadcast()
 	c.Close()
 	if t.deleteConnection(c) {
-		t.openNewConns()
+		t.cl.reopenConns(t)
 	}
 }
 
 func (t *Torrent) wantPeers() bool {
-	if t.closed.IsSet() {
+	if t.closed.IsSet() || t.stopped() {
 		return false
 	}
 	if len(t.peers) > torrentPeersLowWater {
@@ -1149,23 +1298,19 @@ func (t *Torrent) updateWantPeersEvent() {
 
 // Returns whether the client should make effort to seed the torrent.
 func (t *Torrent) seeding() bool {
+	return t.seedAllowed() && !t.needData()
+}
+
+// Returns true if the torrent would seed once it has the data it needs.
+func (t *Torrent) seedAllowed() bool {
 	cl := t.cl
-	if cl.config.NoUpload {
-		return false
-	}
-	if !cl.config.Seed {
-		return false
-	}
-	if t.needData() {
-		return false
-	}
-	return true
+	return cl.config.Seed && !cl.config.NoUpload
 }
 
 // Adds and starts tracker scrapers for tracker URLs that aren't already
 // running.
 func (t *Torrent) startMissingTrackerScrapers() {
-	if t.cl.config.DisableTrackers {
+	if t.cl.config.DisableTrackers || t.stopped() {
 		return
 	}
 	for _, tier := range t.announceList() {
@@ -1234,7 +1379,7 @@ func (t *Torrent) announceDHT(impliedPort bool) {
 					addPeers = append(addPeers, Peer{
 						IP:     cp.IP[:],
 						Port:   cp.Port,
-						Source: peerSourceDHT,
+						Source: PeerSourceDHT,
 					})
 					key := (&net.UDPAddr{
 						IP:   cp.IP[:],
@@ -1260,10 +1405,13 @@ func (t *Torrent) announceDHT(impliedPort bool) {
 }
 
 func (t *Torrent) addPeers(peers []Peer) {
+	var added []Peer
 	for _, p := range peers {
 		if t.cl.badPeerIPPort(p.IP, p.Port) {
 			continue
 		}
 		t.addPeer(p)
+		added = append(added, p)
 	}
+	t.cl.peersDiscovered(t, added)
 }
@@ -3,7 +3,11 @@ package torrent
 import (
 	"testing"
 
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
 	"github.com/anacrolix/torrent/peer_protocol"
+	"github.com/anacrolix/torrent/storage"
 )
 
 func r(i, b, l peer_protocol.Integer) request {
@@ -58,3 +62,26 @@ func TestTorrentString(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+// Storage evicting a piece has it marked incomplete straight away.
+func TestTorrentPieceEvicted(t *testing.T) {
+	cfg := TestingConfig
+	cfg.DefaultStorage = storage.NewMemory(defaultChunkSize)
+	cl, err := NewClient(&cfg)
+	require.NoError(t, err)
+	defer cl.Close()
+	tt := testTorrentPieces(t, cl, 2)
+	data := make([]byte, defaultChunkSize)
+	ps := tt.pieces[0].Storage()
+	_, err = ps.WriteAt(data, 0)
+	require.NoError(t, err)
+	require.NoError(t, ps.MarkComplete())
+	cl.mu.Lock()
+	tt.updatePieceCompletion(0)
+	cl.mu.Unlock()
+	require.True(t, tt.PieceState(0).Complete)
+	// There's only room for one piece.
+	_, err = tt.pieces[1].Storage().WriteAt(data, 0)
+	require.NoError(t, err)
+	assert.False(t, tt.PieceState(0).Complete)
+}
@@ -24,7 +24,7 @@ func trackerToTorrentPeers(ps []tracker.Peer) (ret []Peer) {
 		ret = append(ret, Peer{
 			IP:     p.IP,
 			Port:   p.Port,
-			Source: peerSourceTracker,
+			Source: PeerSourceTracker,
 		})
 	}
 	return
@@ -54,6 +54,17 @@ func (me *trackerScraper) announce() time.Duration {
 	return time.Duration(res.Interval) * time.Second
 }
 
+// Tells the tracker that we've left the swarm. The caller fills out req
+// under the Client lock.
+func (me *trackerScraper) announceStopped(req tracker.AnnounceRequest) {
+	blocked, urlToUse, host, err := me.t.cl.prepareTrackerAnnounceUnlocked(me.url)
+	if err != nil || blocked {
+		return
+	}
+	req.Event = tracker.Stopped
+	tracker.AnnounceHost(urlToUse, &req, host)
+}
+
 func (me *trackerScraper) Run() {
 	for {
 		select {
@@ -26,13 +26,16 @@ type Event struct {
 	MagnetURI string
 	Change
 	TorrentFilePath string
-	InfoHash        metainfo.Hash
+	// The file that MagnetURI was read from.
+	MagnetFilePath string
+	InfoHash       metainfo.Hash
 }
 
 type entity struct {
 	metainfo.Hash
 	MagnetURI       string
 	TorrentFilePath string
+	MagnetFilePath  string
 }
 
 type Instance struct {
@@ -122,8 +125,9 @@ func scanDir(dirName string) (ee map[metainfo.Hash]entity) {
 					continue
 				}
 				addEntity(entity{
-					Hash:      m.InfoHash,
-					MagnetURI: uri,
+					Hash:           m.InfoHash,
+					MagnetURI:      uri,
+					MagnetFilePath: fullName,
 				})
 			}
 		}
@@ -163,6 +167,7 @@ func (i *Instance) torrentAdded(e entity) {
 		Change:          Added,
 		MagnetURI:       e.MagnetURI,
 		TorrentFilePath: e.TorrentFilePath,
+		MagnetFilePath:  e.MagnetFilePath,
 	}
 }
 
@@ -7,7 +7,6 @@ import (
 // Implements heap functions such that [0] is the worst connection.
 type worstConns struct {
 	c  []*connection
-	t  *Torrent
 	cl *Client
 }
 
@@ -42,10 +41,13 @@ func (wc worstConnsSortKey) Less(other worstConnsSortKey) bool {
 	return wc.connected.Before(other.connected)
 }
 
-func (wc *worstConns) key(i int) (key worstConnsSortKey) {
-	c := wc.c[i]
-	key.useful = wc.cl.usefulConn(wc.t, c)
-	if wc.t.seeding() {
+func (wc *worstConns) key(i int) worstConnsSortKey {
+	return wc.cl.worstConnsSortKey(wc.c[i])
+}
+
+func (cl *Client) worstConnsSortKey(c *connection) (key worstConnsSortKey) {
+	key.useful = cl.usefulConn(c.t, c)
+	if c.t.seeding() {
 		key.lastHelpful = c.lastChunkSent
 	}
 	// Intentionally consider the last time a chunk was received when seeding,